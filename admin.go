@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// reloadResult is the body of a /admin/reload response
+type reloadResult struct {
+	Count int `json:"count"` // Number of reviews in the file
+}
+
+// purgeResult is the body of a DELETE /admin/reviews response
+type purgeResult struct {
+	Deleted int `json:"deleted"` // Number of reviews removed
+}
+
+// adminReviewsHandler handles /admin/reviews: GET lists reviews and DELETE
+// purges them with purgeReviews. Other methods are turned away where the
+// route is registered.
+func (s *Server) adminReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.purgeReviews(w, r)
+		return
+	}
+	s.listAdminReviews(w, r)
+}
+
+// listAdminReviews handles GET /admin/reviews, which lists reviews with
+// every field, private ones such as the email included. It takes the same
+// pagination, sorting and filter parameters as GET /reviews, except that
+// reviews of every moderation status are listed unless status is given.
+// flagged=true lists only reviews that readers have flagged, most flagged
+// first unless another sort is given.
+func (s *Server) listAdminReviews(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+	if r.URL.Query().Get("status") == "" {
+		filter.status = ""
+	}
+	if value := r.URL.Query().Get("flagged"); value != "" {
+		flagged, err := strconv.ParseBool(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid flagged value. Must be true or false.")
+			return
+		}
+		filter.flagged = &flagged
+	}
+	s.listReviews(w, r, filter)
+}
+
+// purgeReviews handles DELETE /admin/reviews, which deletes every review,
+// for tearing down test environments. It is refused unless the server is
+// configured to allow purging, so production data can't be wiped by
+// accident. IDs keep counting up from where they were, since the counter is
+// saved with the empty file.
+func (s *Server) purgeReviews(w http.ResponseWriter, r *http.Request) {
+	if !s.config.AllowPurge {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "Purging reviews is disabled. Set ALLOW_PURGE to enable it.")
+		return
+	}
+
+	// Lock the mutex before modifying the reviews
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list, err := s.readReviews(r.Context())
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	// Count the purged IDs, so they aren't handed out again
+	s.countIDs(list)
+	if err := s.writeReviews(r.Context(), []Review{}); err != nil {
+		storageError(w, r, err)
+		return
+	}
+	s.productStats.invalidateAll()
+
+	requestLogger(r).Warn("Purged all reviews", "count", len(list))
+	writeJSON(w, http.StatusOK, purgeResult{Deleted: len(list)})
+}
+
+// reloadHandler handles POST /admin/reload, for when the reviews file has
+// been edited out-of-band. Reviews are read from the file on every request,
+// but cached listings and product stats and the review count in the metrics
+// would otherwise stay stale until the next write. The file is migrated
+// first if the edit left it at an older schema version.
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.migrate(r.Context()); err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	// Hold the write lock so no request caches a listing from before the
+	// reload after the purge
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list, err := s.readReviews(r.Context())
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	if s.cache != nil {
+		s.cache.purge()
+	}
+	s.productStats.invalidateAll()
+	s.metrics.setReviews(countUndeleted(list))
+
+	writeJSON(w, http.StatusOK, reloadResult{Count: len(list)})
+}
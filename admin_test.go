@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestEmailShownOnlyToAdmins(t *testing.T) {
+	ts := newTestServer(t, nil)
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": "Ann", "email": "ann@example.com", "review": "Fine", "rating": 4, "product_id": "p1"}, nil)
+	var created Review
+	decodeBody(t, w, &created)
+	if created.Email != "ann@example.com" {
+		t.Errorf("POST with the API key: got email %q", created.Email)
+	}
+	path := fmt.Sprintf("/reviews/%d", created.ID)
+	ts.do(t, http.MethodPost, path+"/approve", nil, nil)
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"admin", nil, "ann@example.com"},
+		{"reader", anonymous(), ""},
+	} {
+		var got Review
+		decodeBody(t, ts.do(t, http.MethodGet, path, nil, tc.header), &got)
+		if got.Email != tc.want {
+			t.Errorf("GET as %s: got email %q, want %q", tc.name, got.Email, tc.want)
+		}
+	}
+}
+
+func TestEmailHiddenWithoutCredentials(t *testing.T) {
+	// Nobody is an admin without credentials configured, not even the author
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": "Ann", "email": "ann@example.com", "review": "Fine", "rating": 4, "product_id": "p1"}, nil)
+	var created Review
+	decodeBody(t, w, &created)
+	if w.Code != http.StatusCreated || created.Email != "" {
+		t.Errorf("POST: got %d with email %q, want %d without one", w.Code, created.Email, http.StatusCreated)
+	}
+	if got := ts.storedReview(t, created.ID).Email; got != "ann@example.com" {
+		t.Errorf("got email %q saved, want it kept", got)
+	}
+}
+
+func TestAdminReviewsListEveryStatus(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Fine")
+	pending := ts.submit(t, "Bob", "Good")
+
+	w := ts.do(t, http.MethodGet, "/admin/reviews", nil, nil)
+	var list []Review
+	decodeBody(t, w, &list)
+	if len(list) != 2 {
+		t.Fatalf("got %+v, want both reviews", list)
+	}
+	w = ts.do(t, http.MethodGet, "/admin/reviews?status=pending", nil, nil)
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].ID != pending.ID {
+		t.Errorf("status=pending: got %+v, want review %d only", list, pending.ID)
+	}
+}
+
+func TestAdminReviewsRequireAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if w := ts.do(t, http.MethodGet, "/admin/reviews", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	ts = newTestServer(t, map[string]string{"API_KEY": ""})
+	if w := ts.do(t, http.MethodGet, "/admin/reviews", nil, anonymous()); w.Code != http.StatusForbidden {
+		t.Errorf("no credentials configured: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestClientIPRecordedForAdmins(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"TRUST_PROXY": "true"})
+	header := http.Header{"X-Forwarded-For": {"::ffff:192.0.2.7, 10.0.0.1"}}
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1", "ip": "203.0.113.1"}, header)
+	var created Review
+	decodeBody(t, w, &created)
+	if created.IP != "192.0.2.7" {
+		t.Errorf("POST with the API key: got IP %q, want the normalized client address", created.IP)
+	}
+	path := fmt.Sprintf("/reviews/%d", created.ID)
+	ts.do(t, http.MethodPost, path+"/approve", nil, nil)
+
+	var got Review
+	decodeBody(t, ts.do(t, http.MethodGet, path, nil, anonymous()), &got)
+	if got.IP != "" {
+		t.Errorf("GET without credentials: got IP %q, want it hidden", got.IP)
+	}
+}
+
+func TestPurgeRequiresAllowPurge(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Fine")
+	if w := ts.do(t, http.MethodDelete, "/admin/reviews", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("without ALLOW_PURGE: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	ts = newTestServer(t, map[string]string{"ALLOW_PURGE": "true"})
+	ts.post(t, "Ann", "Fine")
+	ts.submit(t, "Bob", "Good")
+	if w := ts.do(t, http.MethodDelete, "/admin/reviews", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w := ts.do(t, http.MethodDelete, "/admin/reviews", nil, nil)
+	var result purgeResult
+	decodeBody(t, w, &result)
+	if w.Code != http.StatusOK || result.Deleted != 2 {
+		t.Errorf("purge: got %d %+v, want both reviews deleted", w.Code, result)
+	}
+	if list := readDocument(t, ts.config.ReviewsFile).Reviews; len(list) != 0 {
+		t.Errorf("got %d reviews left, want none", len(list))
+	}
+}
+
+func TestReloadMigratesAndCounts(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Fine")
+
+	// An out-of-band edit restores a file from before next_id was recorded
+	if err := os.WriteFile(ts.config.ReviewsFile, []byte(`{"schema_version":4,"reviews":[{"id":7,"name":"Ann","review":"Fine","rating":4,"status":"approved"},{"id":9,"name":"Bob","review":"Good","rating":5,"status":"approved"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := ts.do(t, http.MethodPost, "/admin/reload", nil, nil)
+	var result reloadResult
+	decodeBody(t, w, &result)
+	if w.Code != http.StatusOK || result.Count != 2 {
+		t.Fatalf("got %d %+v, want a count of 2", w.Code, result)
+	}
+	if doc := readDocument(t, ts.config.ReviewsFile); doc.SchemaVersion != schemaVersion || doc.NextID != 10 {
+		t.Errorf("got version %d with next_id %d, want version %d with next_id 10", doc.SchemaVersion, doc.NextID, schemaVersion)
+	}
+}
+
+func TestReloadRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if w := ts.do(t, http.MethodPost, "/admin/reload", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	ts = newTestServer(t, map[string]string{"API_KEY": ""})
+	if w := ts.do(t, http.MethodPost, "/admin/reload", nil, anonymous()); w.Code != http.StatusForbidden {
+		t.Errorf("no credentials configured: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
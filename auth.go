@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Credentials accepted by the write and admin endpoints, selected with
+// AUTH_MODE
+const (
+	authModeKey   = "key"   // The X-API-Key header
+	authModeBasic = "basic" // HTTP Basic auth, for browser-based tools
+	authModeBoth  = "both"  // Either of them
+)
+
+// Challenge sent with a 401 when Basic auth is accepted, so browsers prompt
+// for credentials
+const basicAuthChallenge = `Basic realm="reviews", charset="UTF-8"`
+
+// withAuth is a middleware function that rejects mutating requests (POST,
+// PUT, PATCH and DELETE) without valid credentials before the handler runs,
+// apart from those allowed by publicWrite. Other methods stay public. It
+// lets every write through when no credentials are configured.
+func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if !publicWrite(r) && s.config.authEnabled() && !s.isAdmin(r) {
+				writeJSON(w, http.StatusUnauthorized, s.unauthorizedError())
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// publicWrite reports whether r is a write that any client may make, which
+// is voting on a review or flagging it for moderators
+func publicWrite(r *http.Request) bool {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/reviews/") {
+		return false
+	}
+	for _, action := range []string{"/flag", "/upvote", "/downvote"} {
+		if strings.HasSuffix(r.URL.Path, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdmin is a middleware function that rejects every request without
+// valid credentials, whatever its method. Unlike withAuth it stays closed
+// when no credentials are configured, since the endpoints it guards expose
+// private data.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowAdmin(w, r) {
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allowAdmin checks r like requireAdmin, for admin-only actions on routes
+// that are otherwise behind withAuth. If r isn't from an admin it writes a
+// 403 when no credentials are configured or a 401 otherwise, and returns
+// false.
+func (s *Server) allowAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !s.config.authEnabled() {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "Admin access is disabled because no credentials are configured")
+		return false
+	}
+	if !s.isAdmin(r) {
+		writeJSON(w, http.StatusUnauthorized, s.unauthorizedError())
+		return false
+	}
+	return true
+}
+
+// unauthorizedError returns the 401 for a request without valid
+// credentials. It challenges the client for Basic credentials when those
+// are accepted.
+func (s *Server) unauthorizedError() *apiError {
+	if !s.config.acceptsBasicAuth() {
+		return newAPIError(http.StatusUnauthorized, "unauthorized", "Missing or invalid API key")
+	}
+	apiErr := newAPIError(http.StatusUnauthorized, "unauthorized", "Missing or invalid credentials")
+	apiErr.challenge = basicAuthChallenge
+	return apiErr
+}
+
+// isAdmin reports whether r carries credentials accepted by AUTH_MODE: the
+// configured API key, or the Basic auth username and password. Credentials
+// are compared in constant time so their contents can't be guessed from
+// response times. Like requireAdmin it fails closed: nobody is an admin when
+// no credentials are configured, so pending, rejected and deleted reviews
+// stay hidden.
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.config.acceptsAPIKey() {
+		key := r.Header.Get("X-API-Key")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.config.APIKey)) == 1 {
+			return true
+		}
+	}
+	if s.config.acceptsBasicAuth() {
+		if user, password, ok := r.BasicAuth(); ok {
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.config.BasicAuthUser))
+			passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.config.BasicAuthPassword))
+			return userOK&passwordOK == 1
+		}
+	}
+	return false
+}
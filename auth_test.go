@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWritesRequireAPIKey(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d", review.ID)
+	body := map[string]interface{}{"name": "Bob", "review": "Good", "rating": 5, "product_id": "p1"}
+
+	for _, header := range []http.Header{anonymous(), {"X-Api-Key": {"wrong"}}} {
+		for _, req := range []struct{ method, path string }{
+			{http.MethodPost, "/reviews"},
+			{http.MethodPut, path},
+			{http.MethodPatch, path},
+			{http.MethodDelete, path},
+		} {
+			w := ts.do(t, req.method, req.path, body, header)
+			var apiErr apiError
+			decodeBody(t, w, &apiErr)
+			if w.Code != http.StatusUnauthorized || apiErr.Code != "unauthorized" || w.Header().Get("WWW-Authenticate") != "" {
+				t.Errorf("%s %s with key %q: got %d %+v with challenge %q, want %d without a challenge", req.method, req.path, header.Get("X-Api-Key"), w.Code, apiErr, w.Header().Get("WWW-Authenticate"), http.StatusUnauthorized)
+			}
+		}
+	}
+	if got := ts.storedReview(t, review.ID); got.Review != "Fine" || got.DeletedAt != nil {
+		t.Errorf("refused writes changed the review: %+v", got)
+	}
+
+	// Reads stay public
+	if w := ts.do(t, http.MethodGet, path, nil, anonymous()); w.Code != http.StatusOK {
+		t.Errorf("GET %s without credentials: got %d, want %d", path, w.Code, http.StatusOK)
+	}
+}
+
+func TestWritesOpenWithoutCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	review := ts.submit(t, "Ann", "Fine")
+
+	if w := ts.do(t, http.MethodDelete, fmt.Sprintf("/reviews/%d", review.ID), nil, anonymous()); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE without credentials configured: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if ts.storedReview(t, review.ID).DeletedAt == nil {
+		t.Error("review not deleted")
+	}
+}
+
+// basicAuth returns the header of a request with only the given Basic
+// credentials, for do
+func basicAuth(user, password string) http.Header {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth(user, password)
+	header := anonymous()
+	header.Set("Authorization", r.Header.Get("Authorization"))
+	return header
+}
+
+func TestBasicAuth(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"AUTH_MODE": authModeBasic, "BASIC_AUTH_USER": "admin", "BASIC_AUTH_PASSWORD": "secret"})
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}, basicAuth("admin", "secret"))
+	var review Review
+	decodeBody(t, w, &review)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("submit with Basic credentials: got %d %s", w.Code, w.Body)
+	}
+	path := fmt.Sprintf("/reviews/%d/approve", review.ID)
+
+	// The API key isn't accepted in basic mode
+	for _, header := range []http.Header{nil, anonymous(), basicAuth("admin", "wrong")} {
+		w := ts.do(t, http.MethodPost, path, nil, header)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != basicAuthChallenge {
+			t.Errorf("with header %v: got %d with challenge %q, want %d with %q", header, w.Code, w.Header().Get("WWW-Authenticate"), http.StatusUnauthorized, basicAuthChallenge)
+		}
+	}
+	if w := ts.do(t, http.MethodPost, path, nil, basicAuth("admin", "secret")); w.Code != http.StatusOK {
+		t.Errorf("with Basic credentials: got %d %s", w.Code, w.Body)
+	}
+	if w := ts.do(t, http.MethodGet, "/admin/reviews", nil, basicAuth("admin", "secret")); w.Code != http.StatusOK {
+		t.Errorf("admin listing with Basic credentials: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestBothAuthModes(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"AUTH_MODE": authModeBoth, "BASIC_AUTH_USER": "admin", "BASIC_AUTH_PASSWORD": "secret"})
+
+	for _, header := range []http.Header{nil, basicAuth("admin", "secret")} {
+		if w := ts.do(t, http.MethodGet, "/admin/reviews", nil, header); w.Code != http.StatusOK {
+			t.Errorf("with header %v: got %d, want %d", header, w.Code, http.StatusOK)
+		}
+	}
+	if w := ts.do(t, http.MethodGet, "/admin/reviews", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestBasicAuthConfig(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"AUTH_MODE": "token"}, "AUTH_MODE"},
+		{map[string]string{"BASIC_AUTH_USER": "admin"}, "BASIC_AUTH_PASSWORD"},
+	} {
+		t.Run(tc.want, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("loadConfig with %v: got error %v, want one about %s", tc.env, err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// backupHandler handles GET /admin/backup, which downloads a copy of the
+// stored reviews in the reviews file format for disaster recovery. Reading
+// them under the read lock gives a consistent snapshot; the lock is only
+// held while they are loaded into memory, not while they are sent. The
+// download can be restored by putting it in place of the reviews file and
+// calling /admin/reload.
+func (s *Server) backupHandler(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	data, err := s.store.loadDocument(r.Context())
+	s.mutex.RUnlock()
+	if err == nil && data == nil {
+		// No reviews have been saved yet; back up an empty file
+		data, err = json.MarshalIndent(reviewsDocument{SchemaVersion: schemaVersion, NextID: 1, Reviews: []Review{}}, "", "  ")
+	}
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	filename := "reviews-" + time.Now().UTC().Format("20060102-150405") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Write(data)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBackupDownloadsReviewsFile(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.submit(t, "Ann", "Fine")
+
+	w := ts.do(t, http.MethodGet, "/admin/backup", nil, nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment; filename=reviews-") {
+		t.Fatalf("got %d with Content-Disposition %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+	var doc reviewsDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SchemaVersion != schemaVersion || len(doc.Reviews) != 1 || doc.Reviews[0].ID != review.ID || doc.Reviews[0].IP == "" {
+		t.Errorf("got %+v, want the whole file with the pending review and its private fields", doc)
+	}
+}
+
+func TestBackupOfEmptyStore(t *testing.T) {
+	ts := newTestServer(t, nil)
+	w := ts.do(t, http.MethodGet, "/admin/backup", nil, nil)
+	var doc reviewsDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || doc.NextID != 1 || doc.Reviews == nil || len(doc.Reviews) != 0 {
+		t.Errorf("got %d %+v, want an empty reviews file", w.Code, doc)
+	}
+}
+
+func TestBackupRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if w := ts.do(t, http.MethodGet, "/admin/backup", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	ts = newTestServer(t, map[string]string{"API_KEY": ""})
+	if w := ts.do(t, http.MethodGet, "/admin/backup", nil, anonymous()); w.Code != http.StatusForbidden {
+		t.Errorf("no credentials configured: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "net/http"
+
+// bulkResult is the body of a /reviews/bulk response
+type bulkResult struct {
+	Inserted int          `json:"inserted"`
+	Skipped  int          `json:"skipped"`
+	IDs      []int        `json:"ids"`    // IDs assigned to the inserted reviews, in order
+	Errors   []entryError `json:"errors"` // Why each skipped review was rejected
+}
+
+// entryError describes why one review in a batch was rejected
+type entryError struct {
+	Index int    `json:"index"` // Position of the review in the request
+	Error string `json:"error"`
+}
+
+// bulkHandler handles importing many reviews at once. Each review is
+// validated like a single submission and invalid ones are skipped; the
+// valid ones are saved together in one write, so a storage failure saves
+// none of them. Reviews keep their created_at if one is supplied.
+func (s *Server) bulkHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the JSON request body
+	var batch []Review
+	if !s.decodeJSON(w, r, &batch, "Invalid request payload. Expected an array of reviews.") {
+		return
+	}
+
+	result := bulkResult{IDs: []int{}, Errors: []entryError{}}
+	valid := make([]Review, 0, len(batch))
+	for i, review := range batch {
+		msg := ""
+		if review.ParentID != nil {
+			msg = "Replies can't be imported in bulk"
+		}
+		if msg == "" {
+			msg = s.validateNewReview(&review).message()
+		}
+		if msg == "" {
+			msg = s.filterProfanity(&review)
+		}
+		if msg != "" {
+			result.Errors = append(result.Errors, entryError{Index: i, Error: msg})
+			continue
+		}
+		s.escapeForStorage(&review)
+		// Votes, flags and the like are the server's to set, and the address
+		// is only recorded for reviews submitted one at a time
+		clearServerFields(&review)
+		valid = append(valid, review)
+	}
+
+	if len(valid) > 0 {
+		added, err := s.addReviews(r.Context(), valid)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		for _, review := range added {
+			result.IDs = append(result.IDs, review.ID)
+		}
+	}
+
+	result.Inserted = len(result.IDs)
+	result.Skipped = len(result.Errors)
+	writeJSON(w, http.StatusOK, result)
+}
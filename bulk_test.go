@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBulkImportSkipsInvalidReviews(t *testing.T) {
+	ts := newTestServer(t, nil)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	batch := []map[string]interface{}{
+		{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1", "created_at": created, "upvotes": 9, "status": statusApproved},
+		{"name": "Bob", "review": "Good", "rating": 9, "product_id": "p1"},
+		{"name": "Cat", "review": "Great", "rating": 5, "product_id": "p1", "parent_id": 1},
+	}
+
+	w := ts.do(t, http.MethodPost, "/reviews/bulk", batch, nil)
+	var result bulkResult
+	decodeBody(t, w, &result)
+	if w.Code != http.StatusOK || result.Inserted != 1 || result.Skipped != 2 || len(result.Errors) != 2 || result.Errors[0].Index != 1 || result.Errors[1].Index != 2 {
+		t.Fatalf("got %d %+v, want review 0 inserted and reviews 1 and 2 skipped", w.Code, result)
+	}
+
+	got := ts.storedReview(t, result.IDs[0])
+	if !got.CreatedAt.Equal(created) || got.Upvotes != 0 || got.Status != statusPending {
+		t.Errorf("got %+v, want created_at kept, no votes and pending", got)
+	}
+}
+
+func TestBulkImportRequiresCredentials(t *testing.T) {
+	ts := newTestServer(t, nil)
+	batch := []map[string]interface{}{{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}}
+
+	if w := ts.do(t, http.MethodPost, "/reviews/bulk", batch, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var list []Review
+	decodeBody(t, ts.do(t, http.MethodGet, "/admin/reviews", nil, nil), &list)
+	if len(list) != 0 {
+		t.Errorf("got %d reviews saved, want none", len(list))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// lruCache holds recent responses, evicting the least recently used once it
+// is full. Every change to the reviews purges it. Across a purge put is a
+// no-op for values computed beforehand, so a slow reader can't put back a
+// result that is already stale.
+type lruCache struct {
+	mu         sync.Mutex
+	size       int
+	order      *list.List // Front is most recently used
+	items      map[string]*list.Element
+	generation uint64 // Incremented by every purge
+}
+
+// cacheEntry is the value of an element in lruCache.order
+type cacheEntry struct {
+	key      string
+	response cachedResponse
+}
+
+// cachedResponse is a successful response as sent to the client
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+// newLRUCache returns an empty cache holding up to size responses
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// get returns the response cached under key, if any
+func (c *lruCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).response, true
+}
+
+// currentGeneration returns the generation to pass to put for a response
+// computed from now on
+func (c *lruCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches response under key, unless the cache has been purged since
+// generation was read
+func (c *lruCache) put(key string, generation uint64, response cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).response = response
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, response: response})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// purge empties the cache
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = map[string]*list.Element{}
+	c.generation++
+}
+
+// withCache is a middleware function that serves GET requests from the
+// response cache, keyed by path and query parameters, and caches successful
+// responses on a miss. Requests carrying credentials bypass the cache, since
+// their responses may include data the public doesn't see, and so do NDJSON
+// listings, which may be too large to keep. It does nothing when caching is
+// disabled.
+func (s *Server) withCache(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cache == nil || r.Method != http.MethodGet || r.Header.Get("X-API-Key") != "" || r.Header.Get("Authorization") != "" || wantsNDJSON(r) {
+			next(w, r)
+			return
+		}
+
+		// Encode sorts the parameters, so their order doesn't matter
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+		if cached, ok := s.cache.get(key); ok {
+			for name, values := range cached.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.Write(cached.body)
+			return
+		}
+
+		generation := s.cache.currentGeneration()
+		w.Header().Set("X-Cache", "MISS")
+		before := w.Header().Clone()
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status == http.StatusOK {
+			// Only keep the headers the handler set. Those set by outer
+			// middleware, such as CORS, depend on the request and are set
+			// again on a hit.
+			header := http.Header{}
+			for name, values := range w.Header() {
+				if _, ok := before[name]; !ok {
+					header[name] = values
+				}
+			}
+			s.cache.put(key, generation, cachedResponse{header: header, body: rec.body.Bytes()})
+		}
+	}
+}
+
+// cacheRecorder passes a response through while keeping a copy of it
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code before passing it on
+func (rec *cacheRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write copies the body before passing it on
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings, read from the environment
+type Config struct {
+	Port              string        // PORT: port to listen on
+	LogLevel          slog.Level    // LOG_LEVEL: least severe messages logged: debug, info, warn or error
+	LogFormat         string        // LOG_FORMAT: "text" or "json"
+	DBDriver          string        // DB_DRIVER: storage for the reviews; only "file" is supported
+	ReviewsFile       string        // DB_PATH: file to persist reviews
+	SlowQuery         time.Duration // SLOW_QUERY_THRESHOLD: storage operations taking longer are logged
+	RequestTimeout    time.Duration // REQUEST_TIMEOUT: how long a request may run
+	AllowedOrigins    []string      // CORS_ALLOWED_ORIGINS: comma-separated; "*" allows any origin
+	CORSMaxAge        time.Duration // CORS_MAX_AGE: how long browsers may cache a preflight response; 0 disables
+	ProfanityFile     string        // PROFANITY_FILE: word list to filter; no filtering when empty
+	ProfanityMode     string        // PROFANITY_MODE: "reject" or "mask"
+	SentimentFile     string        // SENTIMENT_FILE: extra word scores for sentiment classification
+	HTMLEscape        string        // HTML_ESCAPE: escape names and review text on "write", on "read", or "off"
+	WebhookURLs       []string      // WEBHOOK_URLS: comma-separated URLs to POST new reviews to
+	WebhookTimeout    time.Duration // WEBHOOK_TIMEOUT: how long each webhook delivery attempt may take
+	ReadOnly          bool          // READ_ONLY: reject every change to the reviews, for maintenance
+	AllowPurge        bool          // ALLOW_PURGE: allow DELETE /admin/reviews to delete every review, for tests
+	StartupRetries    int           // STARTUP_RETRIES: times to retry loading the reviews at startup
+	StartupRetryDelay time.Duration // STARTUP_RETRY_DELAY: wait before the first retry, doubling after each one
+	RateLimit         int           // RATE_LIMIT_PER_MINUTE: reviews each client may post per minute; 0 disables
+	FlagRateLimit     int           // FLAG_RATE_LIMIT_PER_MINUTE: reviews each client may flag per minute; 0 disables
+	VoteRateLimit     int           // VOTE_RATE_LIMIT_PER_MINUTE: votes each client may cast per minute; 0 disables
+	FlagThreshold     int           // FLAG_THRESHOLD: flags that send an approved review back to moderation; 0 disables
+	TrustProxy        bool          // TRUST_PROXY: take the client IP from X-Forwarded-For
+	AuthMode          string        // AUTH_MODE: credentials accepted by write and admin endpoints: "key", "basic" or "both"
+	APIKey            string        // API_KEY: key required by write endpoints; no auth when empty
+	BasicAuthUser     string        // BASIC_AUTH_USER: username for HTTP Basic auth; no Basic auth when empty
+	BasicAuthPassword string        // BASIC_AUTH_PASSWORD: password for BASIC_AUTH_USER
+	CacheSize         int           // CACHE_SIZE: listing responses to cache; 0 disables
+	TLSCertFile       string        // TLS_CERT_FILE: certificate to serve HTTPS with
+	TLSKeyFile        string        // TLS_KEY_FILE: private key for TLSCertFile
+	BaseURL           string        // BASE_URL: public URL of the server for absolute links, such as https://reviews.example.com; taken from each request when empty
+	RequireEmail      bool          // REQUIRE_EMAIL: reject new reviews without an author email
+	AnonymousName     string        // ANONYMOUS_NAME: name given to new reviews submitted without one
+	DedupeWindow      time.Duration // DEDUPE_WINDOW: how long an identical name and text is rejected as a duplicate
+	IdempotencyTTL    time.Duration // IDEMPOTENCY_TTL: how long an Idempotency-Key is remembered
+	EditWindow        time.Duration // EDIT_WINDOW: how long after posting a review may be edited without the API key
+	MaxNameLength     int           // MAX_NAME_LENGTH: longest reviewer name in characters; 0 disables
+	MaxReviewLength   int           // MAX_REVIEW_LENGTH: longest review text in characters; 0 disables
+	MaxBodySize       int           // MAX_BODY_SIZE: largest JSON or CSV upload request body in bytes; 0 disables
+}
+
+// Defaults used when an environment variable is unset
+const (
+	defaultPort           = "8080"
+	defaultLogLevel       = "info"
+	defaultLogFormat      = logFormatText
+	defaultDBDriver       = driverFile
+	defaultReviewsFile    = "reviews.json"
+	defaultRequestTimeout = 5 * time.Second
+	defaultDedupeWindow   = 5 * time.Minute
+	defaultIdempotencyTTL = 24 * time.Hour
+	defaultEditWindow     = 15 * time.Minute
+	defaultWebhookTimeout = 5 * time.Second
+	defaultCORSMaxAge     = 10 * time.Minute
+	defaultSlowQuery      = 200 * time.Millisecond
+	defaultAnonymousName  = "Anonymous"
+
+	defaultStartupRetries    = 5
+	defaultStartupRetryDelay = 500 * time.Millisecond
+
+	// Any origin may call the API unless CORS_ALLOWED_ORIGINS says otherwise,
+	// which is convenient for local development
+	defaultAllowedOrigins = "*"
+
+	defaultAuthMode      = authModeKey
+	defaultProfanityMode = profanityReject
+	defaultHTMLEscape    = escapeOnWrite
+	defaultRateLimit     = 10
+	defaultFlagRateLimit = 5
+	defaultVoteRateLimit = 30
+	defaultFlagThreshold = 3
+	defaultCacheSize     = 100
+
+	defaultMaxNameLength   = 100
+	defaultMaxReviewLength = 5000
+	defaultMaxBodySize     = 1 << 20
+)
+
+// loadConfig reads the server settings from the environment
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Port:           getEnv("PORT", defaultPort),
+		LogFormat:      getEnv("LOG_FORMAT", defaultLogFormat),
+		DBDriver:       getEnv("DB_DRIVER", defaultDBDriver),
+		ReviewsFile:    getEnv("DB_PATH", defaultReviewsFile),
+		AllowedOrigins: splitList(getEnv("CORS_ALLOWED_ORIGINS", defaultAllowedOrigins)),
+		ProfanityFile:  os.Getenv("PROFANITY_FILE"),
+		ProfanityMode:  getEnv("PROFANITY_MODE", defaultProfanityMode),
+		SentimentFile:  os.Getenv("SENTIMENT_FILE"),
+		HTMLEscape:     getEnv("HTML_ESCAPE", defaultHTMLEscape),
+		WebhookURLs:    splitList(os.Getenv("WEBHOOK_URLS")),
+		AuthMode:       getEnv("AUTH_MODE", defaultAuthMode),
+		APIKey:         os.Getenv("API_KEY"),
+		BasicAuthUser:  os.Getenv("BASIC_AUTH_USER"),
+		TLSCertFile:    os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:     os.Getenv("TLS_KEY_FILE"),
+		BaseURL:        strings.TrimSuffix(os.Getenv("BASE_URL"), "/"),
+		AnonymousName:  strings.TrimSpace(getEnv("ANONYMOUS_NAME", defaultAnonymousName)),
+	}
+
+	if n, err := strconv.Atoi(cfg.Port); err != nil || n < 1 || n > 65535 {
+		return cfg, fmt.Errorf("invalid PORT %q: must be a number between 1 and 65535", cfg.Port)
+	}
+
+	var err error
+	if cfg.LogLevel, err = parseLogLevel(getEnv("LOG_LEVEL", defaultLogLevel)); err != nil {
+		return cfg, err
+	}
+	if cfg.LogFormat != logFormatText && cfg.LogFormat != logFormatJSON {
+		return cfg, fmt.Errorf("invalid LOG_FORMAT %q: must be %s or %s", cfg.LogFormat, logFormatText, logFormatJSON)
+	}
+
+	// Other backends plug in as implementations of reviewStore. A database
+	// such as PostgreSQL needs a driver, which the server doesn't include as
+	// it has no dependencies outside the standard library.
+	if cfg.DBDriver != driverFile {
+		return cfg, fmt.Errorf("invalid DB_DRIVER %q: only %s is supported", cfg.DBDriver, driverFile)
+	}
+
+	switch cfg.AuthMode {
+	case authModeKey, authModeBasic, authModeBoth:
+	default:
+		return cfg, fmt.Errorf("invalid AUTH_MODE %q: must be %s, %s or %s", cfg.AuthMode, authModeKey, authModeBasic, authModeBoth)
+	}
+	cfg.BasicAuthPassword = os.Getenv("BASIC_AUTH_PASSWORD")
+	if (cfg.BasicAuthUser == "") != (cfg.BasicAuthPassword == "") {
+		return cfg, fmt.Errorf("BASIC_AUTH_USER and BASIC_AUTH_PASSWORD must be set together")
+	}
+
+	if cfg.AnonymousName == "" {
+		return cfg, fmt.Errorf("invalid ANONYMOUS_NAME: must not be blank")
+	}
+
+	if cfg.ProfanityMode != profanityReject && cfg.ProfanityMode != profanityMask {
+		return cfg, fmt.Errorf("invalid PROFANITY_MODE %q: must be %s or %s", cfg.ProfanityMode, profanityReject, profanityMask)
+	}
+
+	switch cfg.HTMLEscape {
+	case escapeOnWrite, escapeOnRead, escapeOff:
+	default:
+		return cfg, fmt.Errorf("invalid HTML_ESCAPE %q: must be %s, %s or %s", cfg.HTMLEscape, escapeOnWrite, escapeOnRead, escapeOff)
+	}
+
+	for _, raw := range cfg.WebhookURLs {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid webhook URL %q in WEBHOOK_URLS: must be an absolute http or https URL", raw)
+		}
+	}
+
+	if cfg.BaseURL != "" {
+		if u, err := url.Parse(cfg.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return cfg, fmt.Errorf("invalid BASE_URL %q: must be an absolute http or https URL without a query", cfg.BaseURL)
+		}
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	cfg.RequestTimeout, err = getEnvDuration("REQUEST_TIMEOUT", defaultRequestTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.SlowQuery, err = getEnvDuration("SLOW_QUERY_THRESHOLD", defaultSlowQuery)
+	if err != nil {
+		return cfg, err
+	}
+	// Unlike the other durations, CORS_MAX_AGE may be 0, which turns
+	// preflight caching off, so only an unset or empty variable gets the
+	// default
+	cfg.CORSMaxAge = defaultCORSMaxAge
+	if value := os.Getenv("CORS_MAX_AGE"); value != "" {
+		cfg.CORSMaxAge, err = time.ParseDuration(value)
+		if err != nil || cfg.CORSMaxAge < 0 {
+			return cfg, fmt.Errorf("invalid CORS_MAX_AGE %q: must be a duration such as 10m, or 0 to disable preflight caching", value)
+		}
+	}
+	cfg.DedupeWindow, err = getEnvDuration("DEDUPE_WINDOW", defaultDedupeWindow)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.IdempotencyTTL, err = getEnvDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.WebhookTimeout, err = getEnvDuration("WEBHOOK_TIMEOUT", defaultWebhookTimeout)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.EditWindow, err = getEnvDuration("EDIT_WINDOW", defaultEditWindow)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.StartupRetries, err = getEnvInt("STARTUP_RETRIES", defaultStartupRetries)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.StartupRetryDelay, err = getEnvDuration("STARTUP_RETRY_DELAY", defaultStartupRetryDelay)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RateLimit, err = getEnvInt("RATE_LIMIT_PER_MINUTE", defaultRateLimit)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.FlagRateLimit, err = getEnvInt("FLAG_RATE_LIMIT_PER_MINUTE", defaultFlagRateLimit)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.VoteRateLimit, err = getEnvInt("VOTE_RATE_LIMIT_PER_MINUTE", defaultVoteRateLimit)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.FlagThreshold, err = getEnvInt("FLAG_THRESHOLD", defaultFlagThreshold)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TrustProxy, err = getEnvBool("TRUST_PROXY", false)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.CacheSize, err = getEnvInt("CACHE_SIZE", defaultCacheSize)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxNameLength, err = getEnvInt("MAX_NAME_LENGTH", defaultMaxNameLength)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxReviewLength, err = getEnvInt("MAX_REVIEW_LENGTH", defaultMaxReviewLength)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.MaxBodySize, err = getEnvInt("MAX_BODY_SIZE", defaultMaxBodySize)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ReadOnly, err = getEnvBool("READ_ONLY", false)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.AllowPurge, err = getEnvBool("ALLOW_PURGE", false)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RequireEmail, err = getEnvBool("REQUIRE_EMAIL", false)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// useTLS reports whether the server should serve HTTPS
+func (cfg Config) useTLS() bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+}
+
+// acceptsAPIKey reports whether clients can authenticate with the X-API-Key
+// header
+func (cfg Config) acceptsAPIKey() bool {
+	return cfg.APIKey != "" && cfg.AuthMode != authModeBasic
+}
+
+// acceptsBasicAuth reports whether clients can authenticate with HTTP Basic
+// credentials
+func (cfg Config) acceptsBasicAuth() bool {
+	return cfg.BasicAuthUser != "" && cfg.AuthMode != authModeKey
+}
+
+// authEnabled reports whether any credentials are configured. Without them
+// write endpoints are open and admin endpoints are closed.
+func (cfg Config) authEnabled() bool {
+	return cfg.acceptsAPIKey() || cfg.acceptsBasicAuth()
+}
+
+// getEnv returns the value of the environment variable key, or def if it is
+// unset or empty
+func getEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvDuration parses the environment variable key as a positive
+// duration such as "5s", returning def if it is unset or empty
+func getEnvDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration such as 5s", key, value)
+	}
+	return d, nil
+}
+
+// getEnvInt parses the environment variable key as a non-negative integer,
+// returning def if it is unset or empty
+func getEnvInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, value)
+	}
+	return n, nil
+}
+
+// getEnvBool parses the environment variable key as a boolean such as
+// "true" or "0", returning def if it is unset or empty
+func getEnvBool(key string, def bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", key, value)
+	}
+	return b, nil
+}
+
+// splitList splits a comma-separated list, dropping blank entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns of the CSV export, in order
+var csvHeader = []string{"id", "product_id", "name", "review", "rating", "created_at"}
+
+// exportHandler handles downloading the reviews selected by the filter
+// parameters (all approved ones by default) as a CSV file. Rows are written
+// to the client one at a time; encoding/csv quotes any field that contains a
+// comma, quote or newline. The response is flushed before the first row, so
+// withTimeout sends the rows on as they are written rather than holding the
+// whole file back. Names and text are written as plain text, so they aren't
+// escaped twice when the file is imported again.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	list = filter.apply(list)
+	sortReviews(list, false)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=reviews.csv")
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, review := range list {
+		createdAt := ""
+		if !review.CreatedAt.IsZero() {
+			createdAt = review.CreatedAt.Format(time.RFC3339)
+		}
+		cw.Write([]string{strconv.Itoa(review.ID), review.ProductID, s.plainText(review.Name), s.plainText(review.Review), strconv.Itoa(review.Rating), createdAt})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		requestLogger(r).Error("Failed to write CSV export", "error", err)
+	}
+}
+
+// Largest part of a CSV upload kept in memory; the rest spills to disk
+const maxImportMemory = 10 << 20
+
+// importResult is the body of a /reviews/import response
+type importResult struct {
+	Inserted int        `json:"inserted"`
+	Skipped  int        `json:"skipped"`
+	IDs      []int      `json:"ids"`    // IDs assigned to the inserted reviews, in order
+	Errors   []rowError `json:"errors"` // Why each skipped row was rejected
+}
+
+// rowError describes why one row of a CSV import was rejected
+type rowError struct {
+	Line  int    `json:"line"` // Line number in the uploaded file, counting the header as line 1
+	Error string `json:"error"`
+}
+
+// importHandler handles uploading reviews as a CSV file in the "file" field
+// of a multipart form. The first row is a header naming the columns, as in
+// the export; product_id, name, review and rating are required, created_at
+// and email are optional and id is ignored since imported reviews get new
+// IDs. Invalid rows are skipped and reported by line, and the valid ones are
+// saved together in one write. The upload is limited to the same size as
+// JSON bodies.
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	s.limitBody(w, r)
+	if err := r.ParseMultipartForm(maxImportMemory); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid_payload", "Expected a multipart form upload")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_payload", "Missing CSV file in the \"file\" field")
+		return
+	}
+	defer file.Close()
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1 // Check row lengths ourselves to report them per line
+
+	header, err := cr.Read()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_payload", "Missing CSV header row")
+		return
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"product_id", "name", "review", "rating"} {
+		if _, ok := columns[required]; !ok {
+			writeJSONError(w, http.StatusBadRequest, "invalid_payload", "CSV header is missing the "+required+" column")
+			return
+		}
+	}
+
+	result := importResult{IDs: []int{}, Errors: []rowError{}}
+	var valid []Review
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A row that fails to parse has no fields to take its line from
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				writeJSONError(w, http.StatusBadRequest, "invalid_payload", "Failed to read the uploaded file")
+				return
+			}
+			result.Errors = append(result.Errors, rowError{Line: parseErr.StartLine, Error: err.Error()})
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+
+		review, msg := parseCSVReview(record, columns)
+		if msg == "" {
+			msg = s.validateNewReview(&review).message()
+		}
+		if msg == "" {
+			msg = s.filterProfanity(&review)
+		}
+		if msg != "" {
+			result.Errors = append(result.Errors, rowError{Line: line, Error: msg})
+			continue
+		}
+		s.escapeForStorage(&review)
+		valid = append(valid, review)
+	}
+
+	if len(valid) > 0 {
+		added, err := s.addReviews(r.Context(), valid)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		for _, review := range added {
+			result.IDs = append(result.IDs, review.ID)
+		}
+	}
+
+	result.Inserted = len(result.IDs)
+	result.Skipped = len(result.Errors)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseCSVReview builds a review from a CSV record using the column
+// positions from the header. It returns a message if a field can't be parsed.
+func parseCSVReview(record []string, columns map[string]int) (Review, string) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	review := Review{ProductID: field("product_id"), Name: field("name"), Review: field("review"), Email: field("email")}
+
+	rating, err := strconv.Atoi(strings.TrimSpace(field("rating")))
+	if err != nil {
+		return review, "Invalid rating value. Must be between 1 and 5."
+	}
+	review.Rating = rating
+
+	if value := strings.TrimSpace(field("created_at")); value != "" {
+		createdAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return review, "Invalid created_at value. Must be an RFC 3339 timestamp."
+		}
+		review.CreatedAt = createdAt.UTC()
+	}
+	return review, ""
+}
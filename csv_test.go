@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExportWritesCSV(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Fine, \"really\"\nfine")
+	ts.submit(t, "Bob", "Pending")
+
+	w := ts.do(t, http.MethodGet, "/reviews/export", nil, anonymous())
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=reviews.csv" {
+		t.Errorf("got Content-Disposition %q", got)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][2] != "Ann" || rows[1][3] != "Fine, \"really\"\nfine" {
+		t.Errorf("got rows %q, want the header and the approved review", rows)
+	}
+}
+
+func TestExportBypassesTimeoutBuffer(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Fine")
+
+	// The recorder is only flushed if the export commits its response to the
+	// client before returning; the timeout writer's own finish doesn't flush
+	w := httptest.NewRecorder()
+	withTimeout(ts.handler, time.Minute).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reviews/export", nil))
+	if w.Code != http.StatusOK || !w.Flushed {
+		t.Errorf("got %d with flushed %v, want 200 sent before the handler finished", w.Code, w.Flushed)
+	}
+}
+
+// importCSV uploads contents to /reviews/import as the file field of a
+// multipart form, with the API key unless anonymous is set
+func (ts *testServer) importCSV(t *testing.T, contents string, anonymous bool) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "reviews.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(contents))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/reviews/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if !anonymous {
+		r.Header.Set("X-API-Key", testAPIKey)
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestImportSkipsInvalidRows(t *testing.T) {
+	ts := newTestServer(t, nil)
+	w := ts.importCSV(t, "id,product_id,name,review,rating\n7,p1,Ann,Fine,4\n8,p1,Bob,Good,9\n9,p1,Cat\n", false)
+
+	var result importResult
+	decodeBody(t, w, &result)
+	if w.Code != http.StatusOK || result.Inserted != 1 || result.Skipped != 2 || len(result.Errors) != 2 || result.Errors[0].Line != 3 || result.Errors[1].Line != 4 {
+		t.Fatalf("got %d %+v, want line 2 inserted and lines 3 and 4 skipped", w.Code, result)
+	}
+	if got := ts.storedReview(t, result.IDs[0]); got.ID == 7 || got.Name != "Ann" || got.Status != statusPending {
+		t.Errorf("got %+v, want Ann's review pending under a new ID", got)
+	}
+}
+
+func TestImportRejectsBadUploads(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if w := ts.importCSV(t, "product_id,name,review\np1,Ann,Fine\n", false); w.Code != http.StatusBadRequest {
+		t.Errorf("missing rating column: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := ts.importCSV(t, "product_id,name,review,rating\np1,Ann,Fine,4\n", true); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Most problems listed by a storage check; the rest are only counted
+const maxCheckProblems = 100
+
+// dbCheckResult is the body of a /admin/dbcheck response
+type dbCheckResult struct {
+	OK            bool           `json:"ok"`
+	Problems      []string       `json:"problems"` // Inconsistencies found in the file; empty when OK
+	Path          string         `json:"path"`
+	FileSize      int64          `json:"file_size"` // In bytes; 0 when the file doesn't exist yet
+	SchemaVersion int            `json:"schema_version"`
+	Reviews       int            `json:"reviews"`
+	ByStatus      map[string]int `json:"by_status"` // Number of reviews with each moderation status
+	Unrated       int            `json:"unrated"`   // Number of reviews saved before ratings were required, with rating 0
+}
+
+// dbCheckHandler handles GET /admin/dbcheck, which checks the reviews file
+// for corruption, for example after a crash or disk failure. Beyond parsing,
+// it checks that every review has a unique positive ID below next_id, a
+// valid status, a rating from 1 to 5 or 0 if it was saved before ratings,
+// and a parent that exists if it is a reply. It also reports the review
+// counts and the file size.
+func (s *Server) dbCheckHandler(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := dbCheckResult{Problems: []string{}, Path: s.store.location(), ByStatus: map[string]int{}}
+	data, err := s.store.loadDocument(r.Context())
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	if data == nil {
+		result.OK = true
+		result.SchemaVersion = schemaVersion
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	result.FileSize = int64(len(data))
+
+	var doc reviewsDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		result.Problems = append(result.Problems, fmt.Sprintf("File doesn't parse: %v", err))
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	result.SchemaVersion = doc.SchemaVersion
+	result.Reviews = len(doc.Reviews)
+	result.Problems = checkReviews(doc)
+	result.OK = len(result.Problems) == 0
+	for _, review := range doc.Reviews {
+		result.ByStatus[review.Status]++
+		if review.Rating == 0 {
+			result.Unrated++
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// checkReviews returns the inconsistencies in doc, at most maxCheckProblems
+// of them
+func checkReviews(doc reviewsDocument) []string {
+	problems := []string{}
+	more := 0
+	report := func(format string, args ...interface{}) {
+		if len(problems) < maxCheckProblems {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		} else {
+			more++
+		}
+	}
+
+	if doc.SchemaVersion != schemaVersion {
+		report("Schema version is %d, want %d", doc.SchemaVersion, schemaVersion)
+	}
+
+	byID := map[int]Review{}
+	for i, review := range doc.Reviews {
+		if review.ID <= 0 {
+			report("Review at index %d has invalid ID %d", i, review.ID)
+			continue
+		}
+		if _, ok := byID[review.ID]; ok {
+			report("ID %d is used by more than one review", review.ID)
+		}
+		if review.ID >= doc.NextID {
+			report("Review %d has an ID at or above next_id %d", review.ID, doc.NextID)
+		}
+		byID[review.ID] = review
+	}
+
+	for _, review := range doc.Reviews {
+		if !validStatus(review.Status) {
+			report("Review %d has invalid status %q", review.ID, review.Status)
+		}
+		// Reviews saved before ratings load with rating 0
+		if review.Rating < 0 || review.Rating > 5 {
+			report("Review %d has invalid rating %d", review.ID, review.Rating)
+		}
+		if review.ParentID != nil {
+			parent, ok := byID[*review.ParentID]
+			if !ok {
+				report("Review %d replies to review %d, which doesn't exist", review.ID, *review.ParentID)
+			} else if parent.ParentID != nil {
+				report("Review %d replies to review %d, which is a reply itself", review.ID, *review.ParentID)
+			}
+		}
+	}
+
+	if more > 0 {
+		problems = append(problems, fmt.Sprintf("...and %d more", more))
+	}
+	return problems
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDBCheckAcceptsUnratedReviews(t *testing.T) {
+	path := writeTestFile(t, "reviews.json", `{"schema_version":4,"reviews":[{"id":1,"name":"Ann","review":"Fine","status":"approved"}]}`)
+	ts := newTestServer(t, map[string]string{"DB_PATH": path})
+
+	w := ts.do(t, http.MethodGet, "/admin/dbcheck", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	var result dbCheckResult
+	decodeBody(t, w, &result)
+	if !result.OK || result.Unrated != 1 || result.Reviews != 1 {
+		t.Errorf("got %+v, want ok with 1 unrated review", result)
+	}
+}
+
+func TestDBCheckReportsProblems(t *testing.T) {
+	doc := fmt.Sprintf(`{"schema_version":%d,"next_id":3,"reviews":[
+		{"id":1,"name":"Ann","review":"Fine","rating":6,"status":"approved"},
+		{"id":1,"name":"Bob","review":"Good","rating":4,"status":"unknown"},
+		{"id":5,"name":"Cid","review":"Meh","rating":3,"status":"approved","parent_id":9}]}`, schemaVersion)
+	ts := newTestServer(t, map[string]string{"DB_PATH": writeTestFile(t, "reviews.json", doc)})
+
+	w := ts.do(t, http.MethodGet, "/admin/dbcheck", nil, nil)
+	var result dbCheckResult
+	decodeBody(t, w, &result)
+	want := []string{
+		"ID 1 is used by more than one review",
+		"Review 5 has an ID at or above next_id 3",
+		"Review 1 has invalid rating 6",
+		"Review 1 has invalid status \"unknown\"",
+		"Review 5 replies to review 9, which doesn't exist",
+	}
+	if result.OK || fmt.Sprint(result.Problems) != fmt.Sprint(want) {
+		t.Errorf("got problems %q, want %q", result.Problems, want)
+	}
+}
+
+func TestDBCheckRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if w := ts.do(t, http.MethodGet, "/admin/dbcheck", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	ts = newTestServer(t, map[string]string{"API_KEY": ""})
+	if w := ts.do(t, http.MethodGet, "/admin/dbcheck", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("without credentials configured: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// withETag is a middleware function that tags successful GET responses with
+// an ETag computed from their body, so the tag changes whenever a review in
+// the response is added, updated or deleted. A request whose If-None-Match
+// header holds the current tag gets a 304 Not Modified without the body.
+// The tag is weak because gzip may change the bytes sent. NDJSON listings
+// are streamed without a tag. HEAD requests are tagged like GET, so the tag
+// can be checked without downloading the body.
+func withETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Streamed listings would have to be held back in full
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || wantsNDJSON(r) {
+			next(w, r)
+			return
+		}
+
+		rec := &etagRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(rec.body.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header value lists etag.
+// Comparison is weak, so a strong form of the same tag matches too.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagRecorder holds back a response so its ETag can be computed before
+// anything is sent
+type etagRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code to send later
+func (rec *etagRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// Write buffers the body to send later
+func (rec *etagRecorder) Write(p []byte) (int, error) {
+	return rec.body.Write(p)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReviewsListETag(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Fine")
+
+	w := ts.do(t, http.MethodGet, "/reviews", nil, anonymous())
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("got %d with ETag %q, want %d with a weak tag", w.Code, etag, http.StatusOK)
+	}
+
+	for _, match := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		header := anonymous()
+		header.Set("If-None-Match", match)
+		w := ts.do(t, http.MethodGet, "/reviews", nil, header)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: got %d with %d bytes and ETag %q, want %d with none and %q", match, w.Code, w.Body.Len(), w.Header().Get("ETag"), http.StatusNotModified, etag)
+		}
+	}
+
+	// Adding a review changes the tag, so the old one no longer matches
+	ts.post(t, "Bob", "Good")
+	header := anonymous()
+	header.Set("If-None-Match", etag)
+	w = ts.do(t, http.MethodGet, "/reviews", nil, header)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("after a write: got %d with ETag %q, want %d with a new tag", w.Code, w.Header().Get("ETag"), http.StatusOK)
+	}
+}
+
+func TestReviewsListETagOnlyOnSuccess(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	header := anonymous()
+	header.Set("If-None-Match", "*")
+	w := ts.do(t, http.MethodGet, "/reviews?status=pending", nil, header)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("ETag") != "" {
+		t.Errorf("without credentials: got %d with ETag %q, want %d without a tag", w.Code, w.Header().Get("ETag"), http.StatusUnauthorized)
+	}
+}
+
+func TestReviewsListETagWithoutCredentials(t *testing.T) {
+	// Without credentials configured every reader sees the same list
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	ts.submit(t, "Ann", "Fine")
+
+	etag := ts.do(t, http.MethodGet, "/reviews", nil, nil).Header().Get("ETag")
+	header := http.Header{"If-None-Match": {etag}}
+	if w := ts.do(t, http.MethodGet, "/reviews", nil, header); etag == "" || w.Code != http.StatusNotModified {
+		t.Errorf("got %d for ETag %q, want %d", w.Code, etag, http.StatusNotModified)
+	}
+}
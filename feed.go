@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Number of entries /reviews/feed.xml has by default and at most
+const (
+	defaultFeedEntries = 20
+	maxFeedEntries     = 50
+)
+
+// atomFeed is an Atom 1.0 feed (RFC 4287) of reviews
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink links a feed or entry to the resource it describes
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// atomEntry is a single review in an atomFeed
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+// atomAuthor names the author of an entry
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// atomContent is the plain-text body of an entry
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// feedHandler handles /reviews/feed.xml, an Atom feed of the n most recent
+// reviews for feed readers. The reviews can be narrowed down with the filter
+// parameters like a listing, and n is capped at maxFeedEntries. Names and
+// text are sent as plain text, which encoding/xml escapes, so they are
+// unescaped first if the server saves them HTML-escaped.
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := queryInt(r, "n", defaultFeedEntries)
+	if err != nil || n < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid n value. Must be a positive integer.")
+		return
+	}
+	if n > maxFeedEntries {
+		n = maxFeedEntries
+	}
+
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	// The self link is the URL the client requested, with the version
+	// prefix that was stripped before routing
+	base := s.baseURL(r)
+	self := base + apiPath(r, r.URL.Path)
+	if r.URL.RawQuery != "" {
+		self += "?" + r.URL.RawQuery
+	}
+	feed := atomFeed{
+		ID:      base + apiPath(r, "/reviews/feed.xml"),
+		Title:   "Reviews",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Rel: "self", Href: self},
+	}
+	if filter.productID != "" {
+		feed.Title = "Reviews of " + filter.productID
+	}
+	for i, review := range newestReviews(filter.apply(list), n) {
+		created := review.CreatedAt.UTC().Format(time.RFC3339)
+		if i == 0 {
+			// The newest entry is the last time the feed changed
+			feed.Updated = created
+		}
+		name := s.plainText(review.Name)
+		url := base + apiPath(r, "/reviews/"+strconv.Itoa(review.ID))
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        url,
+			Title:     fmt.Sprintf("%s rated %d out of 5", name, review.Rating),
+			Published: created,
+			Updated:   created,
+			Author:    atomAuthor{Name: name},
+			Link:      atomLink{Rel: "alternate", Href: url},
+			Content:   atomContent{Type: "text", Text: s.plainText(review.Review)},
+		})
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		requestLogger(r).Error("Failed to encode feed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// baseURL returns the URL the server is reached at, such as
+// https://reviews.example.com, for the absolute links a feed needs. It is
+// BASE_URL if set. Otherwise it is built from the scheme and the Host
+// header of r, which the client controls, so deployments behind a proxy or
+// serving feeds to the public should set BASE_URL.
+func (s *Server) baseURL(r *http.Request) string {
+	if s.config.BaseURL != "" {
+		return s.config.BaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
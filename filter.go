@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reviewFilter selects reviews by the query parameters shared by the
+// endpoints that list or summarize reviews
+type reviewFilter struct {
+	query     string    // q: case-insensitive substring of the name or text
+	productID string    // product_id: only reviews of this product
+	status    string    // status: only reviews with this moderation status; any when empty
+	sentiment string    // sentiment: only positive, neutral or negative reviews
+	from      time.Time // from: only reviews submitted at or after this time; open-ended when zero
+	to        time.Time // to: only reviews submitted at or before this time; open-ended when zero
+	verified  *bool     // verified: only reviews by confirmed buyers, or only others; either when nil
+	flagged   *bool     // flagged: only reviews flagged at least once, or only others; admin-only, either when nil
+	tag       string    // tag: only reviews with this tag
+	lang      string    // lang: only reviews in this language, by ISO 639-1 code or "unknown"
+	minRating int       // min_rating: only reviews rated at least this many stars; open-ended when 0
+	maxRating int       // max_rating: only reviews rated at most this many stars; open-ended when 0
+
+	includeDeleted bool // include_deleted: deleted reviews too; admin-only
+
+	unescape bool // Match q against names and text unescaped, as they are stored HTML-escaped
+}
+
+// parseReviewFilter reads the filter query parameters from r. Only approved
+// reviews are selected unless an admin asks for another status.
+func (s *Server) parseReviewFilter(r *http.Request) (reviewFilter, *apiError) {
+	params := r.URL.Query()
+	f := reviewFilter{
+		query:     strings.ToLower(params.Get("q")),
+		productID: strings.TrimSpace(params.Get("product_id")),
+		status:    strings.TrimSpace(params.Get("status")),
+		sentiment: strings.TrimSpace(params.Get("sentiment")),
+		tag:       strings.ToLower(strings.TrimSpace(params.Get("tag"))),
+		lang:      strings.ToLower(strings.TrimSpace(params.Get("lang"))),
+		unescape:  s.config.HTMLEscape == escapeOnWrite,
+	}
+
+	switch f.sentiment {
+	case "", sentimentPositive, sentimentNeutral, sentimentNegative:
+	default:
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid sentiment value. Must be positive, neutral or negative.")
+	}
+
+	var err error
+	if f.from, err = parseTimeParam(params.Get("from"), false); err != nil {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid from value. Must be an RFC 3339 timestamp or a YYYY-MM-DD date.")
+	}
+	if f.to, err = parseTimeParam(params.Get("to"), true); err != nil {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid to value. Must be an RFC 3339 timestamp or a YYYY-MM-DD date.")
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && f.from.After(f.to) {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid date range. from must not be after to.")
+	}
+
+	if f.minRating, err = parseRatingParam(params.Get("min_rating")); err != nil {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid min_rating value. Must be between 1 and 5.")
+	}
+	if f.maxRating, err = parseRatingParam(params.Get("max_rating")); err != nil {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid max_rating value. Must be between 1 and 5.")
+	}
+	if f.minRating > 0 && f.maxRating > 0 && f.minRating > f.maxRating {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid rating range. min_rating must not be above max_rating.")
+	}
+
+	if value := params.Get("verified"); value != "" {
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid verified value. Must be true or false.")
+		}
+		f.verified = &verified
+	}
+
+	if f.status == "" {
+		f.status = statusApproved
+	}
+	if !validStatus(f.status) {
+		return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid status value. Must be pending, approved or rejected.")
+	}
+	if f.status != statusApproved && !s.isAdmin(r) {
+		return f, s.unauthorizedError()
+	}
+
+	if value := params.Get("include_deleted"); value != "" {
+		if f.includeDeleted, err = strconv.ParseBool(value); err != nil {
+			return f, newAPIError(http.StatusBadRequest, "invalid_parameter", "Invalid include_deleted value. Must be true or false.")
+		}
+		if f.includeDeleted && !s.isAdmin(r) {
+			return f, s.unauthorizedError()
+		}
+	}
+	return f, nil
+}
+
+// match reports whether review passes the filter
+func (f reviewFilter) match(review Review) bool {
+	if review.DeletedAt != nil && !f.includeDeleted {
+		return false
+	}
+	if f.status != "" && review.Status != f.status {
+		return false
+	}
+	if !f.from.IsZero() && review.CreatedAt.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && review.CreatedAt.After(f.to) {
+		return false
+	}
+	if f.minRating > 0 && review.Rating < f.minRating {
+		return false
+	}
+	if f.maxRating > 0 && review.Rating > f.maxRating {
+		return false
+	}
+	if f.verified != nil && review.Verified != *f.verified {
+		return false
+	}
+	if f.flagged != nil && (review.Flags > 0) != *f.flagged {
+		return false
+	}
+	if f.sentiment != "" && review.Sentiment != f.sentiment {
+		return false
+	}
+	if f.lang != "" && review.Lang != f.lang {
+		return false
+	}
+	if f.tag != "" && !review.hasTag(f.tag) {
+		return false
+	}
+	if f.productID != "" && review.ProductID != f.productID {
+		return false
+	}
+	if f.query != "" {
+		// Match text as submitted, so q=amp doesn't find every "&"
+		name, text := review.Name, review.Review
+		if f.unescape {
+			name, text = html.UnescapeString(name), html.UnescapeString(text)
+		}
+		if !strings.Contains(strings.ToLower(name), f.query) && !strings.Contains(strings.ToLower(text), f.query) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTimeParam parses a from or to query parameter, which is either an RFC
+// 3339 timestamp or a date. A date stands for the start of that day in UTC,
+// or its last instant when endOfDay is set, so that to=2024-01-31 includes
+// the whole of January 31. An empty value gives the zero time.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		day = day.Add(24*time.Hour - time.Nanosecond)
+	}
+	return day, nil
+}
+
+// parseRatingParam parses a min_rating or max_rating query parameter, which
+// is a star rating from 1 to 5. An empty value gives 0.
+func parseRatingParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	rating, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if rating < 1 || rating > 5 {
+		return 0, fmt.Errorf("rating %d out of range", rating)
+	}
+	return rating, nil
+}
+
+// apply returns the reviews in list that pass the filter. The result is
+// never nil so it encodes as an empty array.
+func (f reviewFilter) apply(list []Review) []Review {
+	matches := []Review{}
+	for _, review := range list {
+		if f.match(review) {
+			matches = append(matches, review)
+		}
+	}
+	return matches
+}
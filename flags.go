@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Longest flag reason in characters
+const maxFlagReasonLength = 500
+
+// flagReport records one reader flagging a review
+type flagReport struct {
+	IP        string    `json:"ip"` // Address of the reader, so each one can flag a review only once
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// flagRequest is the optional body of a flag request
+type flagRequest struct {
+	Reason string `json:"reason"`
+}
+
+// flagResult is the body of a flag response
+type flagResult struct {
+	ID    int `json:"id"`
+	Flags int `json:"flags"`
+}
+
+// handleFlag handles POST /reviews/{id}/flag, with which any reader may
+// report an approved review as inappropriate, optionally giving a reason.
+// Each client may flag a review once, and flags are rate limited per client.
+// When a review reaches the configured number of flags it goes back to
+// pending for a moderator to look at again.
+func (s *Server) handleFlag(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.limitClient(w, r, s.flagLimiter, "Too many reviews flagged. Please try again later.") {
+		return
+	}
+
+	// The body is optional, so an empty one gives no reason
+	var req flagRequest
+	if r.ContentLength != 0 && !s.decodeJSON(w, r, &req, "Invalid request payload. Expected an object with an optional reason.") {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > maxFlagReasonLength {
+		writeJSONError(w, http.StatusBadRequest, "validation_failed", fmt.Sprintf("Reason is too long. Must be at most %d characters.", maxFlagReasonLength))
+		return
+	}
+
+	ip := clientIP(r, s.config.TrustProxy)
+	reopened := false
+	review, ok := s.modifyReview(w, r, id, func(review *Review) *apiError {
+		if review.Status != statusApproved {
+			return newAPIError(http.StatusNotFound, "not_found", "Review not found")
+		}
+		for _, report := range review.FlagReports {
+			if report.IP == ip {
+				return newAPIError(http.StatusConflict, "duplicate", "You have already flagged this review")
+			}
+		}
+
+		review.Flags++
+		review.FlagReports = append(review.FlagReports, flagReport{IP: ip, Reason: s.storedText(req.Reason), CreatedAt: time.Now().UTC()})
+
+		// Only crossing the threshold reopens the review, so one a moderator
+		// approves again isn't sent straight back by the next flag
+		if s.config.FlagThreshold > 0 && review.Flags == s.config.FlagThreshold {
+			review.Status = statusPending
+			reopened = true
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	if reopened {
+		requestLogger(r).Info("Review reached the flag threshold and awaits moderation again", "review_id", review.ID, "flags", review.Flags)
+	}
+	writeJSON(w, http.StatusOK, flagResult{ID: review.ID, Flags: review.Flags})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// reader returns the header of a request without credentials from the
+// reader at ip, for servers that trust X-Forwarded-For
+func reader(ip string) http.Header {
+	header := anonymous()
+	header.Set("X-Forwarded-For", ip)
+	return header
+}
+
+func TestReadersCanFlag(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"TRUST_PROXY": "true"})
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d/flag", review.ID)
+
+	w := ts.do(t, http.MethodPost, path, flagRequest{Reason: "Spam"}, reader("192.0.2.1"))
+	var result flagResult
+	decodeBody(t, w, &result)
+	if w.Code != http.StatusOK || result.Flags != 1 {
+		t.Fatalf("flag without credentials: got %d with %d flags, want %d with 1", w.Code, result.Flags, http.StatusOK)
+	}
+	if w := ts.do(t, http.MethodPost, path, nil, reader("192.0.2.1")); w.Code != http.StatusConflict {
+		t.Errorf("flag twice: got %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	got := ts.storedReview(t, review.ID)
+	if got.Flags != 1 || len(got.FlagReports) != 1 || got.FlagReports[0].Reason != "Spam" {
+		t.Errorf("got %d flags with reports %+v, want 1 with reason %q", got.Flags, got.FlagReports, "Spam")
+	}
+}
+
+func TestFlagsOnlyOnApprovedReviews(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.submit(t, "Ann", "Fine")
+
+	if w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/flag", review.ID), nil, anonymous()); w.Code != http.StatusNotFound {
+		t.Errorf("flag a pending review: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestFlagThresholdReopensReview(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"TRUST_PROXY": "true", "FLAG_THRESHOLD": "2"})
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d", review.ID)
+
+	ts.do(t, http.MethodPost, path+"/flag", nil, reader("192.0.2.1"))
+	if got := ts.storedReview(t, review.ID).Status; got != statusApproved {
+		t.Fatalf("below the threshold: got status %q, want %q", got, statusApproved)
+	}
+	ts.do(t, http.MethodPost, path+"/flag", nil, reader("192.0.2.2"))
+	if got := ts.storedReview(t, review.ID).Status; got != statusPending {
+		t.Fatalf("at the threshold: got status %q, want %q", got, statusPending)
+	}
+
+	w := ts.do(t, http.MethodGet, "/admin/reviews?flagged=true", nil, nil)
+	var list []Review
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].ID != review.ID || len(list[0].FlagReports) != 2 {
+		t.Fatalf("flagged reviews: got %+v, want review %d with 2 reports", list, review.ID)
+	}
+
+	// A moderator approving the review again keeps it up past the threshold
+	if w := ts.do(t, http.MethodPost, path+"/approve", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("approve: got %d %s", w.Code, w.Body)
+	}
+	ts.do(t, http.MethodPost, path+"/flag", nil, reader("192.0.2.3"))
+	if got := ts.storedReview(t, review.ID); got.Status != statusApproved || got.Flags != 3 {
+		t.Errorf("past the threshold: got status %q with %d flags, want %q with 3", got.Status, got.Flags, statusApproved)
+	}
+}
+
+func TestFlagsAreRateLimited(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"FLAG_RATE_LIMIT_PER_MINUTE": "1"})
+	first := ts.post(t, "Ann", "Fine")
+	second := ts.post(t, "Bob", "Good")
+
+	if w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/flag", first.ID), nil, anonymous()); w.Code != http.StatusOK {
+		t.Fatalf("first flag: got %d %s", w.Code, w.Body)
+	}
+	w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/flag", second.ID), nil, anonymous())
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("flag past the limit: got %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestFlaggedReviewsListedForAdmins(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if w := ts.do(t, http.MethodGet, "/admin/reviews?flagged=true", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := ts.do(t, http.MethodGet, "/admin/reviews?flagged=maybe", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid flagged value: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	ts = newTestServer(t, map[string]string{"API_KEY": ""})
+	if w := ts.do(t, http.MethodGet, "/admin/reviews?flagged=true", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("no credentials configured: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
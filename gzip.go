@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Responses smaller than this are sent uncompressed, since gzip would save
+// little and cost CPU on both ends
+const gzipMinSize = 1024
+
+// withGzip is a middleware function that compresses responses with gzip
+// when the client accepts it. Error responses, bodies under gzipMinSize and
+// protocol upgrades such as WebSockets are sent as they are.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.finish()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether r's Accept-Encoding header allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+		// "gzip;q=0" means the client explicitly refuses gzip
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it knows
+// whether the response is worth compressing: a success status and at least
+// gzipMinSize bytes of body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool         // Whether the handler has called WriteHeader
+	buf         []byte       // Body held back until the decision is made
+	decided     bool         // Whether headers have been sent downstream
+	gz          *gzip.Writer // Non-nil once the response is being compressed
+}
+
+// WriteHeader records the status code; it is sent once the body is known to
+// be worth compressing or not
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	gw.status = status
+}
+
+// Write buffers the body until there is enough of it to decide whether to
+// compress, then passes it on
+func (gw *gzipResponseWriter) Write(p []byte) (int, error) {
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(p)
+		}
+		return gw.ResponseWriter.Write(p)
+	}
+
+	gw.buf = append(gw.buf, p...)
+	if len(gw.buf) >= gzipMinSize {
+		if err := gw.decide(gw.status < http.StatusBadRequest); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends any buffered data to the client. A response that is flushed
+// before it has grown large enough to compress is sent uncompressed.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.decide(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide sends the headers, compressed or not, followed by the buffered body
+func (gw *gzipResponseWriter) decide(compress bool) error {
+	gw.decided = true
+	header := gw.ResponseWriter.Header()
+
+	if compress && header.Get("Content-Encoding") == "" {
+		if header.Get("Content-Type") == "" {
+			// Sniff the type from the plain body; it can't be sniffed later
+			header.Set("Content-Type", http.DetectContentType(gw.buf))
+		}
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+	if len(gw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf)
+	}
+	gw.buf = nil
+	return err
+}
+
+// finish sends a response too small to compress, or completes the gzip
+// stream of a compressed one
+func (gw *gzipResponseWriter) finish() {
+	if !gw.decided {
+		gw.decide(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+	}
+}
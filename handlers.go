@@ -0,0 +1,998 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Pagination limits for listing reviews
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+// storageError logs a failure to read or write the reviews file while
+// serving r and responds with a 500, or a 503 if the request ran out of time
+// first
+func storageError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSONError(w, http.StatusServiceUnavailable, "timeout", "Request timed out")
+		return
+	}
+	requestLogger(r).Error("Reviews storage error", "error", err)
+	writeJSONError(w, http.StatusInternalServerError, "storage_error", "Failed to access reviews")
+}
+
+// reviewsHandler handles both POST and GET requests for reviews. HEAD is
+// answered like GET, and net/http leaves out the body. Other methods are
+// turned away where the route is registered.
+func (s *Server) reviewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.handlePostReview(w, r)
+		return
+	}
+	s.handleGetReviews(w, r)
+}
+
+// handlePostReview handles the submission of a new review. A request with an
+// Idempotency-Key header is answered with the review it created the first
+// time if the same key is sent again, so retries create at most one review.
+func (s *Server) handlePostReview(w http.ResponseWriter, r *http.Request) {
+	validateOnly, err := validateOnlyRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid validate value. Must be true or false.")
+		return
+	}
+	if validateOnly {
+		s.validateSubmission(w, r)
+		return
+	}
+
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		if review, ok := s.submitReview(w, r); ok {
+			s.writeCreated(w, r, review)
+		}
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("Idempotency key is too long. Must be at most %d characters.", maxIdempotencyKeyLength))
+		return
+	}
+
+	saved, reserved := s.idempotency.reserve(key, time.Now())
+	if !reserved {
+		if saved == nil {
+			writeJSONError(w, http.StatusConflict, "request_in_progress", "A request with this idempotency key is still in progress")
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		s.writeCreated(w, r, *saved)
+		return
+	}
+
+	review, ok := s.submitReview(w, r)
+	if !ok {
+		// Nothing was created, so a retry may try again
+		s.idempotency.release(key)
+		return
+	}
+	s.idempotency.complete(key, review, time.Now())
+	s.writeCreated(w, r, review)
+}
+
+// writeCreated responds with a newly created review and where to find it
+func (s *Server) writeCreated(w http.ResponseWriter, r *http.Request, review Review) {
+	w.Header().Set("Location", apiPath(r, "/reviews/"+strconv.Itoa(review.ID)))
+	writeJSON(w, http.StatusCreated, s.visibleReview(r, review))
+}
+
+// submitReview validates and saves the review in the body of r. If it fails
+// it writes the error response and returns false.
+func (s *Server) submitReview(w http.ResponseWriter, r *http.Request) (Review, bool) {
+	if !s.checkRateLimit(w, r) {
+		return Review{}, false
+	}
+
+	newReview, ok := s.decodeNewReview(w, r)
+	if !ok {
+		return Review{}, false
+	}
+	newReview, err := s.addReview(r.Context(), newReview)
+	if err != nil {
+		s.writeSubmitError(w, r, err)
+		return Review{}, false
+	}
+
+	s.events.publish(newReview)
+	s.webhooks.notify(s.responseReview(newReview).public(), requestID(r.Context()))
+	return newReview, true
+}
+
+// decodeNewReview parses and validates the review in the body of r and
+// prepares it for storage. If it is invalid it writes the error response
+// and returns false.
+func (s *Server) decodeNewReview(w http.ResponseWriter, r *http.Request) (Review, bool) {
+	// Parse the JSON request body
+	var newReview Review
+	if !s.decodeJSON(w, r, &newReview, "Invalid request payload") {
+		return Review{}, false
+	}
+
+	// Validate the review before it touches the file
+	if errs := s.validateNewReview(&newReview); len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, errs.apiError())
+		return Review{}, false
+	}
+	if msg := s.filterProfanity(&newReview); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, "profanity", msg)
+		return Review{}, false
+	}
+	s.escapeForStorage(&newReview)
+
+	// The submission time and address are always set by the server
+	clearServerFields(&newReview)
+	newReview.CreatedAt = time.Time{}
+	newReview.IP = clientIP(r, s.config.TrustProxy)
+	return newReview, true
+}
+
+// clearServerFields clears the fields of a new review that only the server
+// may set, whatever the client sent in them
+func clearServerFields(review *Review) {
+	review.Status = ""
+	review.IP = ""
+	review.Verified = false // Only set through /reviews/{id}/verify
+	review.Upvotes = 0      // Only counted through /reviews/{id}/upvote and /downvote
+	review.Downvotes = 0
+	review.Flags = 0
+	review.FlagReports = nil
+	review.DeletedAt = nil
+}
+
+// writeSubmitError writes the response for err from addReview
+func (s *Server) writeSubmitError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errDuplicateReview):
+		writeJSONError(w, http.StatusConflict, "duplicate", "An identical review was submitted recently")
+	case errors.Is(err, errParentNotFound):
+		writeJSONError(w, http.StatusBadRequest, "validation_failed", "Parent review not found")
+	case errors.Is(err, errNestedReply):
+		writeJSONError(w, http.StatusBadRequest, "validation_failed", "Replies to replies are not allowed")
+	default:
+		storageError(w, r, err)
+	}
+}
+
+// validateReview strips control characters from the name and review text
+// and trims them, and checks that the review is complete and within the
+// configured length limits. It returns the problem with each invalid field,
+// which is empty if the review is valid. Text that isn't valid UTF-8 is
+// rejected; encoding/json already replaces invalid bytes with U+FFFD, so
+// this catches the reviews of CSV imports.
+func (s *Server) validateReview(review *Review) fieldErrors {
+	errs := fieldErrors{}
+	if !utf8.ValidString(review.Name) {
+		errs.add("name", "must be valid UTF-8")
+	}
+	if !utf8.ValidString(review.Review) {
+		errs.add("review", "must be valid UTF-8")
+	}
+	review.Name = strings.TrimSpace(stripControl(review.Name, false))
+	review.Review = strings.TrimSpace(stripControl(review.Review, true))
+
+	if review.Name == "" {
+		errs.add("name", "required")
+	}
+	if review.Review == "" {
+		errs.add("review", "required")
+	}
+	// Lengths are counted in characters, not bytes
+	if max := s.config.MaxNameLength; max > 0 && utf8.RuneCountInString(review.Name) > max {
+		errs.add("name", fmt.Sprintf("must be at most %d characters", max))
+	}
+	if max := s.config.MaxReviewLength; max > 0 && utf8.RuneCountInString(review.Review) > max {
+		errs.add("review", fmt.Sprintf("must be at most %d characters", max))
+	}
+	if review.Rating < 1 || review.Rating > 5 {
+		errs.add("rating", "must be between 1 and 5")
+	}
+	return errs
+}
+
+// validateNewReview checks a review being submitted for the first time. On
+// top of s.validateReview, new reviews must say which product they are about,
+// unless they reply to another review, their tags are normalized with
+// normalizeTags, and any author email must be a valid address. The email is
+// required when the server is configured to require it. A review without a
+// name is posted anonymously under the configured name.
+func (s *Server) validateNewReview(review *Review) fieldErrors {
+	review.Anonymous = strings.TrimSpace(review.Name) == ""
+	if review.Anonymous {
+		review.Name = s.config.AnonymousName
+	}
+	errs := s.validateReview(review)
+	review.ProductID = strings.TrimSpace(review.ProductID)
+	if review.ProductID == "" && review.ParentID == nil {
+		errs.add("product_id", "required")
+	}
+	if msg := normalizeTags(review); msg != "" {
+		errs.add("tags", msg)
+	}
+
+	review.Email = strings.TrimSpace(review.Email)
+	if review.Email == "" {
+		if s.config.RequireEmail {
+			errs.add("email", "required")
+		}
+		return errs
+	}
+	// Only a bare address is accepted, not a display name form such as
+	// "Jane <jane@example.com>"
+	addr, err := mail.ParseAddress(review.Email)
+	if err != nil || addr.Address != review.Email {
+		errs.add("email", "must be a valid address")
+	}
+	return errs
+}
+
+// handleGetReviews handles fetching submitted reviews, one page at a time.
+// Reviews can be narrowed down with the filter parameters (see reviewFilter)
+// and are ordered by the sort query parameter ("newest" by default,
+// "oldest", "helpful" for the most upvoted relative to downvotes first,
+// "flags" for the most flagged first, or "id" for the highest ID first).
+// The page is selected with the limit and offset query parameters and the
+// total number of matching reviews is reported in the X-Total-Count header.
+// Pages sorted by ID can instead be selected with the after parameter, the
+// ID of the last review the client has seen, which stays stable as reviews
+// are added; the value for the next page is reported in the X-Next-Cursor
+// header.
+// Replies are listed like any other review unless replies=nested, which pages
+// through top-level reviews only and nests the replies under each one. The
+// page is a bare JSON array unless envelope=true, which wraps it in a
+// listPage with the pagination metadata. format=ndjson, or an Accept header
+// asking for application/x-ndjson, streams the reviews as JSON Lines
+// instead, all of them unless a limit is given.
+func (s *Server) handleGetReviews(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+	s.listReviews(w, r, filter)
+}
+
+// listReviews responds with a page of the reviews selected by filter, as
+// described for handleGetReviews
+func (s *Server) listReviews(w http.ResponseWriter, r *http.Request, filter reviewFilter) {
+	after, err := queryInt(r, "after", 0)
+	if err != nil || (r.URL.Query().Get("after") != "" && after < 1) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid after value. Must be a review ID.")
+		return
+	}
+
+	order := r.URL.Query().Get("sort")
+	if order == "" {
+		order = "newest"
+		if after > 0 {
+			order = "id"
+		} else if filter.flagged != nil && *filter.flagged {
+			order = "flags"
+		}
+	}
+	if order != "newest" && order != "oldest" && order != "helpful" && order != "flags" && order != "id" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid sort value. Must be newest, oldest, helpful, flags or id.")
+		return
+	}
+	if after > 0 && order != "id" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "after can only be combined with sort=id")
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json", "ndjson":
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid format value. Must be json or ndjson.")
+		return
+	}
+	ndjson := wantsNDJSON(r)
+
+	limit, err := queryInt(r, "limit", defaultLimit)
+	if err != nil || limit < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid limit value. Must be a positive integer.")
+		return
+	}
+	if limit > maxLimit && !ndjson {
+		limit = maxLimit
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid offset value. Must be a non-negative integer.")
+		return
+	}
+	if after > 0 && offset > 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "after can't be combined with offset")
+		return
+	}
+
+	envelope := false
+	if value := r.URL.Query().Get("envelope"); value != "" {
+		if envelope, err = strconv.ParseBool(value); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid envelope value. Must be true or false.")
+			return
+		}
+	}
+	if envelope && ndjson {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "envelope can't be combined with the ndjson format")
+		return
+	}
+
+	nested := false
+	switch r.URL.Query().Get("replies") {
+	case "", "flat":
+	case "nested":
+		nested = true
+	default:
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid replies value. Must be flat or nested.")
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	list = filter.apply(list)
+	sortReviews(list, order != "oldest")
+	switch order {
+	case "helpful":
+		// Stable, so equally helpful reviews stay newest first
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].helpfulness() > list[j].helpfulness()
+		})
+	case "flags":
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Flags > list[j].Flags
+		})
+	case "id":
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].ID > list[j].ID
+		})
+	}
+	var replies map[int][]Review
+	if nested {
+		list, replies = splitReplies(list)
+	}
+
+	// The page after a cursor starts at the first review below it, so it
+	// doesn't shift when reviews are added in front
+	total := len(list)
+	if after > 0 {
+		start := sort.Search(len(list), func(i int) bool { return list[i].ID < after })
+		list = list[start:]
+	}
+
+	if ndjson && r.URL.Query().Get("limit") == "" {
+		// Streamed listings hold every matching review unless limited
+		limit = len(list)
+	}
+	start := offset
+	if start > len(list) {
+		start = len(list)
+	}
+	end := start + limit
+	if end > len(list) {
+		end = len(list)
+	}
+
+	// A cursor for the next page is only reported while there is one
+	var nextCursor *int
+	if order == "id" && end < len(list) && end > start {
+		nextCursor = &list[end-1].ID
+	}
+
+	var page interface{}
+	var items []interface{} // The page as separate values, for NDJSON
+	if nested {
+		threads := s.nestReplies(r, list[start:end], replies)
+		for _, thread := range threads {
+			items = append(items, thread)
+		}
+		page = threads
+	} else {
+		reviews := s.visibleReviews(r, list[start:end])
+		for _, review := range reviews {
+			items = append(items, review)
+		}
+		page = reviews
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if nextCursor != nil {
+		w.Header().Set("X-Next-Cursor", strconv.Itoa(*nextCursor))
+	}
+	if ndjson {
+		writeNDJSON(w, r, items)
+		return
+	}
+	if envelope {
+		writeJSON(w, http.StatusOK, listPage{Data: page, Total: total, Limit: limit, Offset: offset, NextCursor: nextCursor})
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// listPage is the body of a review listing requested with envelope=true
+type listPage struct {
+	Data   interface{} `json:"data"`  // The reviews on this page
+	Total  int         `json:"total"` // Number of reviews matching the filters across all pages
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+
+	NextCursor *int `json:"next_cursor"` // Value of after for the next page when sorted by ID; null on the last page or in other orders
+}
+
+// sortReviews orders reviews by submission time, breaking ties (such as
+// reviews saved before timestamps were recorded) by ID
+func sortReviews(list []Review, newestFirst bool) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if newestFirst {
+			a, b = b, a
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// decodeJSON decodes the JSON request body into v, rejecting requests that
+// aren't declared as JSON, bodies larger than the configured limit and
+// fields that v doesn't have. If decoding fails it writes the error
+// response, using message for a malformed body, and returns false.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, message string) bool {
+	// ParseMediaType ignores case and surrounding whitespace and allows
+	// parameters such as charset
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json")
+		return false
+	}
+
+	s.limitBody(w, r)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		if bodyTooLarge(w, err) {
+			return false
+		}
+		apiErr := newAPIError(http.StatusBadRequest, "invalid_payload", message)
+		apiErr.Detail = describeDecodeError(err)
+		writeJSON(w, apiErr.Status, apiErr)
+		return false
+	}
+	return true
+}
+
+// limitBody caps the size of the body of r at the configured maximum, so
+// reading past it fails with an error that bodyTooLarge recognizes
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request) {
+	if s.config.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.config.MaxBodySize))
+	}
+}
+
+// bodyTooLarge writes a 413 response and returns true if err came from
+// reading past the limit set by limitBody
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	writeJSONError(w, http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("Request body too large. Must be at most %d bytes.", tooLarge.Limit))
+	return true
+}
+
+// describeDecodeError explains why a JSON request body failed to decode:
+// where the syntax is wrong, which field has the wrong type, or that the
+// body is empty or cut short
+func describeDecodeError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "Request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Request body ends in the middle of a JSON value"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Malformed JSON at byte %d: %s", syntaxErr.Offset, strings.TrimPrefix(syntaxErr.Error(), "json: "))
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Sprintf("Request body must be %s, not %s", jsonTypeName(typeErr.Type), jsonValueName(typeErr.Value))
+		}
+		return fmt.Sprintf("Field %s must be %s, not %s", typeErr.Field, jsonTypeName(typeErr.Type), jsonValueName(typeErr.Value))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// DisallowUnknownFields has no error type of its own
+		return "Unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	return err.Error()
+}
+
+// jsonTypeName describes the JSON values that decode into t
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "an RFC 3339 timestamp string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// jsonValueName describes the JSON value reported by an UnmarshalTypeError,
+// such as "string", or "number 1.5" for a number that doesn't fit the field
+func jsonValueName(value string) string {
+	switch value {
+	case "string", "number":
+		return "a " + value
+	case "array", "object":
+		return "an " + value
+	case "bool":
+		return "a boolean"
+	}
+	return strings.TrimPrefix(value, "number ")
+}
+
+// queryInt parses the named query parameter as an integer, returning def
+// when the parameter is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// deleteReviewHandler handles the deletion of a review by ID
+func (s *Server) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the JSON request body to get the ID of the review to delete
+	var requestData struct {
+		ID int `json:"id"`
+	}
+	if !s.decodeJSON(w, r, &requestData, "Invalid request payload") {
+		return
+	}
+
+	if !s.deleteReview(w, r, requestData.ID) {
+		return
+	}
+
+	// Respond with success
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// reviewHandler handles requests for a single review at /reviews/{id}, and
+// the moderation actions at /reviews/{id}/{action}
+func (s *Server) reviewHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/reviews/")
+	action := ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest, action = rest[:i], rest[i+1:]
+	}
+	id, err := strconv.Atoi(rest)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_id", "Invalid review ID")
+		return
+	}
+	switch action {
+	case "":
+	case "upvote", "downvote":
+		s.handleVote(w, r, id, action == "upvote")
+		return
+	case "verify":
+		s.handleVerifyReview(w, r, id)
+		return
+	case "flag":
+		s.handleFlag(w, r, id)
+		return
+	case "restore":
+		s.handleRestoreReview(w, r, id)
+		return
+	default:
+		s.handleModerateReview(w, r, id, action)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetReview(w, r, id)
+	case http.MethodPut:
+		s.handlePutReview(w, r, id)
+	case http.MethodPatch:
+		s.handlePatchReview(w, r, id)
+	case http.MethodDelete:
+		s.handleDeleteReview(w, r, id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+// handleGetReview handles fetching a single review by ID
+func (s *Server) handleGetReview(w http.ResponseWriter, r *http.Request, id int) {
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	// Reviews awaiting or failing moderation are hidden from the public
+	review := findReview(list, id)
+	if review == nil || (review.Status != statusApproved && !s.isAdmin(r)) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Review not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.visibleReview(r, *review))
+}
+
+// handlePutReview replaces the name, text and rating of an existing review,
+// within the edit window checked by checkEditWindow
+func (s *Server) handlePutReview(w http.ResponseWriter, r *http.Request, id int) {
+	// Parse the JSON request body
+	var update Review
+	if !s.decodeJSON(w, r, &update, "Invalid request payload") {
+		return
+	}
+
+	// Validate the review before it touches the file
+	if errs := s.validateReview(&update); len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, errs.apiError())
+		return
+	}
+	if msg := s.filterProfanity(&update); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, "profanity", msg)
+		return
+	}
+	s.escapeForStorage(&update)
+
+	review, ok := s.modifyReview(w, r, id, func(review *Review) *apiError {
+		if apiErr := s.checkEditWindow(r, *review); apiErr != nil {
+			return apiErr
+		}
+		review.Name = update.Name
+		review.Anonymous = false // The author has named themselves now
+		review.Review = update.Review
+		review.Rating = update.Rating
+		review.Sentiment = s.sentiment.classify(s.plainText(review.Review))
+		review.Lang = defaultDetector.detect(s.plainText(review.Review))
+		return nil
+	})
+	if ok {
+		writeJSON(w, http.StatusOK, s.visibleReview(r, review))
+	}
+}
+
+// checkEditWindow returns a 403 error if review was posted longer ago than
+// the configured edit window, unless the client making r is an admin by the
+// same check as requireAdmin, with the API key or Basic auth. Everyone else
+// is held to the window, and so is everyone when no credentials are
+// configured and withAuth lets writes through. Reviews saved before
+// submission times were recorded are past the window.
+func (s *Server) checkEditWindow(r *http.Request, review Review) *apiError {
+	if s.isAdmin(r) {
+		return nil
+	}
+	expired := review.CreatedAt.Add(s.config.EditWindow)
+	if !time.Now().After(expired) {
+		return nil
+	}
+	if review.CreatedAt.IsZero() {
+		return newAPIError(http.StatusForbidden, "edit_window_expired", "This review can no longer be edited because it was posted before edit times were recorded")
+	}
+	return newAPIError(http.StatusForbidden, "edit_window_expired", fmt.Sprintf("Editing this review expired at %s. Reviews can only be edited within %s of being posted.", expired.UTC().Format(time.RFC3339), s.config.EditWindow))
+}
+
+// reviewPatch is the body of a PATCH request. Fields left out of the JSON
+// are nil and keep their current value.
+type reviewPatch struct {
+	Name   *string `json:"name"`
+	Review *string `json:"review"`
+	Rating *int    `json:"rating"`
+}
+
+// handlePatchReview updates only the fields of an existing review that are
+// present in the request body, within the edit window checked by
+// checkEditWindow
+func (s *Server) handlePatchReview(w http.ResponseWriter, r *http.Request, id int) {
+	// Parse the JSON request body
+	var patch reviewPatch
+	if !s.decodeJSON(w, r, &patch, "Invalid request payload") {
+		return
+	}
+
+	review, ok := s.modifyReview(w, r, id, func(review *Review) *apiError {
+		if apiErr := s.checkEditWindow(r, *review); apiErr != nil {
+			return apiErr
+		}
+
+		// Merge the provided fields into a copy of the stored review, then
+		// validate the result as a whole. The stored name and text are
+		// checked as they were submitted, like the provided ones, rather
+		// than as escaped for storage.
+		merged := *review
+		merged.Name = s.plainText(merged.Name)
+		merged.Review = s.plainText(merged.Review)
+		if patch.Name != nil {
+			merged.Name = *patch.Name
+			merged.Anonymous = false
+		}
+		if patch.Review != nil {
+			merged.Review = *patch.Review
+		}
+		if patch.Rating != nil {
+			merged.Rating = *patch.Rating
+		}
+
+		if errs := s.validateReview(&merged); len(errs) > 0 {
+			return errs.apiError()
+		}
+		if msg := s.filterProfanity(&merged); msg != "" {
+			return newAPIError(http.StatusBadRequest, "profanity", msg)
+		}
+		merged.Name = s.storedText(merged.Name)
+		merged.Review = s.storedText(merged.Review)
+
+		merged.Sentiment = s.sentiment.classify(s.plainText(merged.Review))
+		merged.Lang = defaultDetector.detect(s.plainText(merged.Review))
+		*review = merged
+		return nil
+	})
+	if ok {
+		writeJSON(w, http.StatusOK, s.visibleReview(r, review))
+	}
+}
+
+// modifyReview applies change to the review with the given ID and saves the
+// result, holding the mutex throughout. change may return an error to leave
+// the review as it was. If anything fails modifyReview writes the error
+// response and returns false; otherwise it returns the modified review.
+func (s *Server) modifyReview(w http.ResponseWriter, r *http.Request, id int, change func(review *Review) *apiError) (Review, bool) {
+	// Lock the mutex before modifying the reviews
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list, err := s.readReviews(r.Context())
+	if err != nil {
+		storageError(w, r, err)
+		return Review{}, false
+	}
+
+	review := findReview(list, id)
+	if review == nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Review not found")
+		return Review{}, false
+	}
+
+	if apiErr := change(review); apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return Review{}, false
+	}
+
+	// Save reviews to the file
+	if err := s.writeReviews(r.Context(), list); err != nil {
+		storageError(w, r, err)
+		return Review{}, false
+	}
+	s.productStats.invalidate(review.ProductID)
+	return *review, true
+}
+
+// handleDeleteReview deletes the review with the given ID
+func (s *Server) handleDeleteReview(w http.ResponseWriter, r *http.Request, id int) {
+	if !s.deleteReview(w, r, id) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteReview marks the review with the given ID as deleted. It stays in
+// the file for the record, but is hidden from every endpoint apart from the
+// listings with include_deleted=true, and can be brought back with
+// /reviews/{id}/restore. If it fails it writes the error response and
+// returns false.
+func (s *Server) deleteReview(w http.ResponseWriter, r *http.Request, id int) bool {
+	_, ok := s.modifyReview(w, r, id, func(review *Review) *apiError {
+		now := time.Now().UTC()
+		review.DeletedAt = &now
+		return nil
+	})
+	return ok
+}
+
+// handleRestoreReview handles /reviews/{id}/restore, which undoes the
+// deletion of a review. Like include_deleted it is for admins only.
+func (s *Server) handleRestoreReview(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.allowAdmin(w, r) {
+		return
+	}
+
+	// Lock the mutex before modifying the reviews
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list, err := s.readReviews(r.Context())
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	review := findStoredReview(list, id)
+	if review == nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Review not found")
+		return
+	}
+	if review.DeletedAt == nil {
+		writeJSONError(w, http.StatusConflict, "not_deleted", "Review is not deleted")
+		return
+	}
+	review.DeletedAt = nil
+
+	// Save reviews to the file
+	if err := s.writeReviews(r.Context(), list); err != nil {
+		storageError(w, r, err)
+		return
+	}
+	s.productStats.invalidate(review.ProductID)
+	writeJSON(w, http.StatusOK, s.visibleReview(r, *review))
+}
+
+// visibleReview returns review as the client making r may see it: in full
+// for admins, and without private fields such as the email for everyone else.
+func (s *Server) visibleReview(r *http.Request, review Review) Review {
+	if s.isAdmin(r) {
+		return s.responseReview(review)
+	}
+	return s.responseReview(review).public()
+}
+
+// responseReview returns review as it is sent in responses, with the length
+// of its text as submitted, not as escaped for storage, and its name and
+// text escaped if the server escapes HTML on read
+func (s *Server) responseReview(review Review) Review {
+	text := s.plainText(review.Review)
+	review.CharCount = utf8.RuneCountInString(text)
+	review.WordCount = len(strings.Fields(text))
+	s.escapeForResponse(&review)
+	if review.Tags == nil {
+		review.Tags = []string{} // Saved before tags were tracked
+	}
+	return review
+}
+
+// visibleReviews applies visibleReview to every review in list, without
+// modifying list
+func (s *Server) visibleReviews(r *http.Request, list []Review) []Review {
+	visible := make([]Review, len(list))
+	for i, review := range list {
+		visible[i] = s.visibleReview(r, review)
+	}
+	return visible
+}
+
+// livenessHandler reports that the process is up and serving requests
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessHandler reports whether the server can reach its review storage
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkStorage(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// checkStorage checks that the review storage is reachable
+func (s *Server) checkStorage() error {
+	return s.store.ping()
+}
+
+// apiError is the body of every error response
+type apiError struct {
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+	Code      string `json:"code,omitempty"`       // Machine-readable error identifier
+	Detail    string `json:"detail,omitempty"`     // Specific reason, such as where a malformed body went wrong
+	RequestID string `json:"request_id,omitempty"` // Correlation ID of the failed request, for support
+
+	Errors     fieldErrors `json:"errors,omitempty"`      // Problem with each invalid field of a submitted review
+	RetryAfter int         `json:"retry_after,omitempty"` // Seconds to wait before retrying a rate-limited request, as in Retry-After
+
+	challenge string // WWW-Authenticate header to send with a 401, if any
+}
+
+// newAPIError returns an error response body with the given status code,
+// machine-readable code and human-readable message
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Error: message, Status: status, Code: code}
+}
+
+// writeJSONError writes an error response with the given status code,
+// machine-readable code (which may be empty) and human-readable message
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, newAPIError(status, code, message))
+}
+
+// writeJSON writes v as a JSON response with the given status code. v is
+// encoded in full before anything is sent, so an encoding failure turns into
+// a 500 instead of a truncated body that looks complete. Error bodies are
+// tagged with the request ID set by withRequestID.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	id := w.Header().Get(requestIDHeader)
+	if apiErr, ok := v.(*apiError); ok {
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = id
+		}
+		if apiErr.challenge != "" {
+			w.Header().Set("WWW-Authenticate", apiErr.challenge)
+		}
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to encode response", "request_id", id, "error", err)
+		status = http.StatusInternalServerError
+		apiErr := newAPIError(status, "encoding_error", "Failed to encode response")
+		apiErr.RequestID = id
+		data, _ = json.Marshal(apiErr)
+	}
+
+	data = append(data, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Failed to write response", "request_id", id, "error", err)
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Key the test servers are configured with
+const testAPIKey = "test-key"
+
+// testServer is a server over a reviews file in a temporary directory,
+// configured from the environment like the real one
+type testServer struct {
+	*Server
+	handler http.Handler
+}
+
+// newTestServer starts a server with the API key set and the given extra
+// environment, after migrating its empty reviews file
+func newTestServer(t *testing.T, env map[string]string) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DB_PATH", filepath.Join(dir, "reviews.json"))
+	t.Setenv("API_KEY", testAPIKey)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.migrate(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.routes(mux)
+	return &testServer{Server: s, handler: mux}
+}
+
+// writeTestFile writes contents to a file in a temporary directory and
+// returns its path
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// do sends a request with body encoded as JSON, unless it is nil, and
+// returns the recorded response. header is added to the request, which
+// carries the API key unless header sets X-API-Key itself.
+func (ts *testServer) do(t *testing.T, method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-API-Key", testAPIKey)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	return w
+}
+
+// anonymous returns the header of a request without credentials, for do
+func anonymous() http.Header {
+	return http.Header{"X-Api-Key": nil}
+}
+
+// submit submits a review of product p1 and returns it as saved, awaiting
+// moderation
+func (ts *testServer) submit(t *testing.T, name, text string) Review {
+	t.Helper()
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": name, "review": text, "rating": 4, "product_id": "p1"}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /reviews: got %d %s", w.Code, w.Body)
+	}
+	var review Review
+	decodeBody(t, w, &review)
+	return review
+}
+
+// post submits a review like submit and approves it
+func (ts *testServer) post(t *testing.T, name, text string) Review {
+	t.Helper()
+	review := ts.submit(t, name, text)
+	if w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/approve", review.ID), nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("approve review %d: got %d %s", review.ID, w.Code, w.Body)
+	}
+	return review
+}
+
+// decodeBody decodes the JSON body of w into v
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+// readDocument returns the reviews file at path
+func readDocument(t *testing.T, path string) reviewsDocument {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc reviewsDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// storedReview returns the review with the given ID as saved in the file
+func (ts *testServer) storedReview(t *testing.T, id int) Review {
+	t.Helper()
+	list, err := ts.store.load(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := findStoredReview(list, id)
+	if review == nil {
+		t.Fatalf("review %d is not stored", id)
+	}
+	return *review
+}
+
+func TestPatchKeepsEscapedTextWithinLimits(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"HTML_ESCAPE": escapeOnWrite, "MAX_REVIEW_LENGTH": "20"})
+	review := ts.post(t, "Ann", "<<<<<<<<<<<<<<<<<<<<")
+
+	w := ts.do(t, http.MethodPatch, fmt.Sprintf("/reviews/%d", review.ID), map[string]int{"rating": 3}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH rating: got %d %s", w.Code, w.Body)
+	}
+	if got := ts.storedReview(t, review.ID); got.Review != review.Review || got.Rating != 3 {
+		t.Errorf("stored review %q rated %d, want %q rated 3", got.Review, got.Rating, review.Review)
+	}
+}
+
+func TestPatchMasksTextAsSubmitted(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"HTML_ESCAPE":    escapeOnWrite,
+		"PROFANITY_MODE": profanityMask,
+		"PROFANITY_FILE": writeTestFile(t, "profanity.txt", "amp\n"),
+	})
+	review := ts.post(t, "Ann", "A & B")
+
+	w := ts.do(t, http.MethodPatch, fmt.Sprintf("/reviews/%d", review.ID), map[string]int{"rating": 3}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH rating: got %d %s", w.Code, w.Body)
+	}
+	if got := ts.storedReview(t, review.ID).Review; got != "A &amp; B" {
+		t.Errorf("stored review %q, want %q", got, "A &amp; B")
+	}
+}
+
+func TestPatchEscapesChangedText(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"HTML_ESCAPE": escapeOnWrite})
+	review := ts.post(t, "A & B", "Fine")
+
+	w := ts.do(t, http.MethodPatch, fmt.Sprintf("/reviews/%d", review.ID), map[string]string{"review": "<b>Great</b>"}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH review: got %d %s", w.Code, w.Body)
+	}
+	got := ts.storedReview(t, review.ID)
+	if got.Name != "A &amp; B" || got.Review != "&lt;b&gt;Great&lt;/b&gt;" {
+		t.Errorf("stored name %q and review %q", got.Name, got.Review)
+	}
+}
+
+func TestQueryMatchesTextAsSubmitted(t *testing.T) {
+	for _, mode := range []string{escapeOnWrite, escapeOnRead, escapeOff} {
+		ts := newTestServer(t, map[string]string{"HTML_ESCAPE": mode})
+		ts.post(t, "Ann", "I don't like <b>this</b> & that")
+
+		tests := []struct {
+			path string
+			want int
+		}{
+			{"/reviews?q=don't", 1},
+			{"/reviews?q=amp", 0},
+			{"/reviews/search?q=don't", 1},
+			{"/reviews/search?q=39", 0},
+			{"/reviews/search?q=amp", 0},
+		}
+		for _, test := range tests {
+			w := ts.do(t, http.MethodGet, test.path, nil, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: GET %s: got %d %s", mode, test.path, w.Code, w.Body)
+			}
+			var list []Review
+			decodeBody(t, w, &list)
+			if len(list) != test.want {
+				t.Errorf("%s: GET %s: got %d reviews, want %d", mode, test.path, len(list), test.want)
+			}
+		}
+	}
+}
+
+func TestSummaryKeywordsOfTextAsSubmitted(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []keywordCount
+	}{
+		// Escaped on write, the entity isn't part of the submitted text
+		{escapeOnWrite, []keywordCount{{Word: "tom", Count: 1}}},
+		// Otherwise the text is stored as typed, entity and all
+		{escapeOnRead, []keywordCount{{Word: "amp", Count: 1}, {Word: "tom", Count: 1}}},
+		{escapeOff, []keywordCount{{Word: "amp", Count: 1}, {Word: "tom", Count: 1}}},
+	}
+	for _, test := range tests {
+		ts := newTestServer(t, map[string]string{"HTML_ESCAPE": test.mode})
+		text := "Tom & me"
+		if test.mode != escapeOnWrite {
+			text = "Tom &amp; me"
+		}
+		ts.post(t, "Ann", text)
+
+		w := ts.do(t, http.MethodGet, "/reviews/summary", nil, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: GET /reviews/summary: got %d %s", test.mode, w.Code, w.Body)
+		}
+		var summary reviewSummary
+		decodeBody(t, w, &summary)
+		if fmt.Sprint(summary.Keywords) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got keywords %v, want %v", test.mode, summary.Keywords, test.want)
+		}
+	}
+}
+
+// restart starts a new server over the same reviews file and environment
+func (ts *testServer) restart(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServer(t, map[string]string{"DB_PATH": ts.config.ReviewsFile})
+}
+
+func TestIDsAreNotReusedAfterPurgeAndRestart(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"ALLOW_PURGE": "true"})
+	ts.post(t, "Ann", "First")
+	last := ts.post(t, "Bob", "Second")
+
+	if w := ts.do(t, http.MethodDelete, "/admin/reviews", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("purge: got %d %s", w.Code, w.Body)
+	}
+	ts = ts.restart(t)
+	if review := ts.post(t, "Cid", "Third"); review.ID <= last.ID {
+		t.Errorf("got ID %d after purging ID %d", review.ID, last.ID)
+	}
+}
+
+func TestMigrationRecordsNextID(t *testing.T) {
+	path := writeTestFile(t, "reviews.json", `{"schema_version":4,"reviews":[{"id":7,"name":"Ann","review":"Fine","rating":4,"status":"approved"}]}`)
+	ts := newTestServer(t, map[string]string{"DB_PATH": path})
+
+	doc := readDocument(t, path)
+	if doc.SchemaVersion != schemaVersion || doc.NextID != 8 {
+		t.Errorf("migrated to version %d with next_id %d, want version %d with next_id 8", doc.SchemaVersion, doc.NextID, schemaVersion)
+	}
+	if review := ts.post(t, "Bob", "Good"); review.ID != 8 {
+		t.Errorf("got ID %d, want 8", review.ID)
+	}
+}
+
+func TestMigrationClassifiesWithConfiguredLexicon(t *testing.T) {
+	path := writeTestFile(t, "reviews.json", `{"schema_version":5,"next_id":2,"reviews":[{"id":1,"name":"Ann","review":"Quite zesty","rating":4,"status":"approved","sentiment":"neutral"}]}`)
+	newTestServer(t, map[string]string{"DB_PATH": path, "SENTIMENT_FILE": writeTestFile(t, "lexicon.txt", "zesty 3\n")})
+
+	doc := readDocument(t, path)
+	if got := doc.Reviews[0].Sentiment; got != sentimentPositive {
+		t.Errorf("got sentiment %q, want %q", got, sentimentPositive)
+	}
+}
+
+func TestResponseCountsTextAsSubmitted(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantReview string
+	}{
+		{escapeOnWrite, "&lt;b&gt;don&#39;t&lt;/b&gt; &amp; co"},
+		{escapeOnRead, "&lt;b&gt;don&#39;t&lt;/b&gt; &amp; co"},
+		{escapeOff, "<b>don't</b> & co"},
+	}
+	for _, test := range tests {
+		ts := newTestServer(t, map[string]string{"HTML_ESCAPE": test.mode})
+		posted := ts.post(t, "Ann", "<b>don't</b> & co")
+
+		w := ts.do(t, http.MethodGet, fmt.Sprintf("/reviews/%d", posted.ID), nil, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: GET review: got %d %s", test.mode, w.Code, w.Body)
+		}
+		var review Review
+		decodeBody(t, w, &review)
+		if review.Review != test.wantReview || review.CharCount != 17 || review.WordCount != 3 {
+			t.Errorf("%s: got %q with %d characters and %d words, want %q with 17 and 3", test.mode, review.Review, review.CharCount, review.WordCount, test.wantReview)
+		}
+	}
+}
+
+func TestControlCharactersStrippedBeforeEscaping(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"HTML_ESCAPE": escapeOnWrite})
+	review := ts.post(t, "A\x00nn <3", "Line one\r\nline\ttwo\x07")
+
+	got := ts.storedReview(t, review.ID)
+	if got.Name != "Ann &lt;3" || got.Review != "Line one\nline\ttwo" {
+		t.Errorf("stored name %q and review %q", got.Name, got.Review)
+	}
+}
+
+func TestPendingReviewsHiddenWithoutCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	review := ts.submit(t, "Ann", "Fine")
+
+	for _, path := range []string{"/reviews?status=pending", "/reviews?include_deleted=true"} {
+		if w := ts.do(t, http.MethodGet, path, nil, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s: got %d, want %d", path, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if w := ts.do(t, http.MethodGet, fmt.Sprintf("/reviews/%d", review.ID), nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET pending review: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestEditWindowBypassedByAdmins(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"EDIT_WINDOW":         "1ns",
+		"AUTH_MODE":           authModeBoth,
+		"BASIC_AUTH_USER":     "admin",
+		"BASIC_AUTH_PASSWORD": "secret",
+	})
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d", review.ID)
+
+	if w := ts.do(t, http.MethodPatch, path, map[string]int{"rating": 3}, nil); w.Code != http.StatusOK {
+		t.Errorf("PATCH with the API key: got %d %s", w.Code, w.Body)
+	}
+	basic := httptest.NewRequest(http.MethodGet, "/", nil)
+	basic.SetBasicAuth("admin", "secret")
+	header := http.Header{}
+	header.Set("X-API-Key", "")
+	header.Set("Authorization", basic.Header.Get("Authorization"))
+	if w := ts.do(t, http.MethodPatch, path, map[string]int{"rating": 2}, header); w.Code != http.StatusOK {
+		t.Errorf("PATCH with Basic auth: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestEditWindowHoldsWithoutCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"API_KEY": "", "EDIT_WINDOW": "1ns"})
+	review := ts.submit(t, "Ann", "Fine")
+
+	w := ts.do(t, http.MethodPatch, fmt.Sprintf("/reviews/%d", review.ID), map[string]int{"rating": 3}, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("PATCH past the window: got %d %s, want %d", w.Code, w.Body, http.StatusForbidden)
+	}
+}
+
+func TestOutOfBandEditsServedAfterReload(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.post(t, "Ann", "Good")
+
+	// get lists the reviews and returns the text of the only one, and
+	// whether it came from the response cache
+	get := func(header http.Header) (string, bool) {
+		t.Helper()
+		w := ts.do(t, http.MethodGet, "/reviews", nil, header)
+		var list []Review
+		decodeBody(t, w, &list)
+		if len(list) != 1 {
+			t.Fatalf("got %d reviews, want 1", len(list))
+		}
+		return list[0].Review, w.Header().Get("X-Cache") == "HIT"
+	}
+	get(anonymous())
+
+	path := ts.config.ReviewsFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, bytes.Replace(data, []byte(`"Good"`), []byte(`"Fine"`), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store reads the file every time, but anonymous readers are served
+	// from the response cache until /admin/reload purges it
+	if text, hit := get(nil); text != "Fine" || hit {
+		t.Errorf("with the API key: got %q from the cache %v, want %q read from the file", text, hit, "Fine")
+	}
+	if text, hit := get(anonymous()); text != "Good" || !hit {
+		t.Errorf("anonymous before reload: got %q from the cache %v, want the cached %q", text, hit, "Good")
+	}
+	if w := ts.do(t, http.MethodPost, "/admin/reload", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("reload: got %d %s", w.Code, w.Body)
+	}
+	if text, hit := get(anonymous()); text != "Fine" || hit {
+		t.Errorf("anonymous after reload: got %q from the cache %v, want %q read from the file", text, hit, "Fine")
+	}
+}
+
+func TestDeletedReviewsHiddenAndRestored(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d", review.ID)
+
+	if w := ts.do(t, http.MethodDelete, path, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d %s", w.Code, w.Body)
+	}
+	if ts.storedReview(t, review.ID).DeletedAt == nil {
+		t.Fatal("deleted review has no deleted_at")
+	}
+	if w := ts.do(t, http.MethodGet, path, nil, anonymous()); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted review: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w := ts.do(t, http.MethodGet, "/reviews?include_deleted=true", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("include_deleted without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w := ts.do(t, http.MethodGet, "/reviews?include_deleted=true", nil, nil)
+	var list []Review
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].DeletedAt == nil {
+		t.Errorf("include_deleted with the API key: got %+v, want the deleted review", list)
+	}
+
+	if w := ts.do(t, http.MethodPost, path+"/restore", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("restore without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := ts.do(t, http.MethodPost, path+"/restore", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("restore with the API key: got %d %s", w.Code, w.Body)
+	}
+	if w := ts.do(t, http.MethodPost, path+"/restore", nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("restore a review that isn't deleted: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := ts.do(t, http.MethodGet, path, nil, anonymous()); w.Code != http.StatusOK {
+		t.Errorf("GET restored review: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRestoreDisabledWithoutCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	review := ts.submit(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d", review.ID)
+
+	if w := ts.do(t, http.MethodDelete, path, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d %s", w.Code, w.Body)
+	}
+	if w := ts.do(t, http.MethodPost, path+"/restore", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("restore: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if ts.storedReview(t, review.ID).DeletedAt == nil {
+		t.Error("review restored without credentials configured")
+	}
+}
+
+func TestReadOnlyRejectsWrites(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"READ_ONLY": "true"})
+	handler := ts.withReadOnly(ts.handler)
+	body := map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		data, _ := json.Marshal(body)
+		r := httptest.NewRequest(method, "/reviews", bytes.NewReader(data))
+		r.Header.Set("X-API-Key", testAPIKey)
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s in read-only mode: got %d, want %d", method, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reviews", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET in read-only mode: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
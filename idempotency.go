@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Header with which clients make a review submission safe to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Longest idempotency key accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotencyStore remembers the review created for each idempotency key
+// for a while, so that a retried submission returns it instead of creating
+// another. It is kept in memory, so keys are forgotten on restart.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotencyEntry
+}
+
+// idempotencyEntry is the outcome of the request that first used a key
+type idempotencyEntry struct {
+	review  *Review   // The review created; nil while the request is in progress
+	expires time.Time // When the key is forgotten
+}
+
+// newIdempotencyStore returns a store that remembers keys for ttl. A key
+// reserved by a request that never completes, such as one that panicked,
+// is also forgotten after ttl.
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{ttl: ttl, entries: map[string]*idempotencyEntry{}}
+}
+
+// reserve claims key for a new request and returns true, unless the key is
+// already in use. Then it returns the review the key created, or nil if the
+// request using it is still in progress.
+func (st *idempotencyStore) reserve(key string, now time.Time) (*Review, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if entry, ok := st.entries[key]; ok && now.Before(entry.expires) {
+		return entry.review, false
+	}
+	st.entries[key] = &idempotencyEntry{expires: now.Add(st.ttl)}
+	return nil, true
+}
+
+// complete records the review created by the request that reserved key
+func (st *idempotencyStore) complete(key string, review Review, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.entries[key] = &idempotencyEntry{review: &review, expires: now.Add(st.ttl)}
+}
+
+// release forgets key after the request that reserved it failed without
+// creating a review
+func (st *idempotencyStore) release(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.entries, key)
+}
+
+// cleanup forgets keys that have expired
+func (st *idempotencyStore) cleanup(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, entry := range st.entries {
+		if !now.Before(entry.expires) {
+			delete(st.entries, key)
+		}
+	}
+}
+
+// runCleanup calls cleanup every interval so the store doesn't grow without
+// bound
+func (st *idempotencyStore) runCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		st.cleanup(now)
+	}
+}
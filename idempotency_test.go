@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// submitWithKey submits body as a review with the idempotency key key
+func (ts *testServer) submitWithKey(t *testing.T, key string, body map[string]interface{}, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(idempotencyKeyHeader, key)
+	return ts.do(t, http.MethodPost, "/reviews", body, header)
+}
+
+func TestIdempotencyKeyReplaysSubmission(t *testing.T) {
+	// Without credentials configured anyone may submit reviews
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	body := map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}
+
+	first := ts.submitWithKey(t, "abc", body, nil)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first submission: got %d %s", first.Code, first.Body)
+	}
+	retry := ts.submitWithKey(t, "abc", body, nil)
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: got %d with Idempotent-Replayed %q", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	var created, replayed Review
+	decodeBody(t, first, &created)
+	decodeBody(t, retry, &replayed)
+	if replayed.ID != created.ID || retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("retry: got review %d at %q, want %d at %q", replayed.ID, retry.Header().Get("Location"), created.ID, first.Header().Get("Location"))
+	}
+
+	// Another key creates another review
+	if w := ts.submitWithKey(t, "def", map[string]interface{}{"name": "Bob", "review": "Good", "rating": 5, "product_id": "p1"}, nil); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another key: got %d with Idempotent-Replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	if list := readDocument(t, ts.config.ReviewsFile).Reviews; len(list) != 2 {
+		t.Errorf("got %d reviews saved, want 2", len(list))
+	}
+}
+
+func TestIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	ts := newTestServer(t, nil)
+	invalid := map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 9, "product_id": "p1"}
+	if w := ts.submitWithKey(t, "abc", invalid, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid submission: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Nothing was created, so the same key may be used again
+	valid := map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}
+	if w := ts.submitWithKey(t, "abc", valid, nil); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("after a failure: got %d with Idempotent-Replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyKeyRequiresCredentials(t *testing.T) {
+	ts := newTestServer(t, nil)
+	body := map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}
+
+	if w := ts.submitWithKey(t, "abc", body, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	// The refused request didn't claim the key
+	if w := ts.submitWithKey(t, "abc", body, nil); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("with the API key: got %d with Idempotent-Replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	ts := newTestServer(t, nil)
+	body := map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}
+
+	if w := ts.submitWithKey(t, strings.Repeat("k", maxIdempotencyKeyLength+1), body, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
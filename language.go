@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Language of a review the detector isn't confident about
+const languageUnknown = "unknown"
+
+// Sample text from which the detector learns the languages written in the
+// Latin alphabet
+//
+//go:embed language_samples.txt
+var languageSamplesText string
+
+// defaultDetector is the detector learned from languageSamplesText
+var defaultDetector = mustParseLanguageSamples(languageSamplesText)
+
+// Text with fewer trigrams than this is too short to tell apart languages
+// that share an alphabet
+const minLanguageTrigrams = 12
+
+// How much more likely, per trigram in natural log, the best language must
+// be than the runner-up for the detection to count
+const minLanguageMargin = 0.15
+
+// Scripts used by a single language, which is detected from the script
+// alone. Scripts shared by several languages, such as Cyrillic and Arabic,
+// aren't listed, so text in them is of unknown language.
+var languageScripts = []struct {
+	script *unicode.RangeTable
+	lang   string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Georgian, "ka"},
+	{unicode.Armenian, "hy"},
+}
+
+// trigramModel counts the trigrams in the sample text of one language
+type trigramModel struct {
+	counts map[string]int
+	total  int
+}
+
+// languageDetector tells the language of text with a naive Bayes classifier
+// over letter trigrams. It is a small heuristic meant for review-length
+// text, not a general-purpose detector.
+type languageDetector struct {
+	models     map[string]*trigramModel // Keyed by ISO 639-1 code
+	vocabulary int                      // Number of distinct trigrams in all the samples
+}
+
+// mustParseLanguageSamples parses sample text known to be valid
+func mustParseLanguageSamples(text string) *languageDetector {
+	d := &languageDetector{models: map[string]*trigramModel{}}
+	if err := d.parse(strings.NewReader(text)); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// parse learns from the samples read from r. Each sample starts with a line
+// holding its language code in brackets. Blank lines and lines starting with
+// # are ignored.
+func (d *languageDetector) parse(r io.Reader) error {
+	var model *trigramModel
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			lang := text[1 : len(text)-1]
+			if d.models[lang] == nil {
+				d.models[lang] = &trigramModel{counts: map[string]int{}}
+			}
+			model = d.models[lang]
+			continue
+		}
+		if model == nil {
+			return fmt.Errorf("line %d: text before the first language", line)
+		}
+		for _, trigram := range trigrams(text) {
+			model.counts[trigram]++
+			model.total++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	vocabulary := map[string]bool{}
+	for _, model := range d.models {
+		for trigram := range model.counts {
+			vocabulary[trigram] = true
+		}
+	}
+	d.vocabulary = len(vocabulary)
+	return nil
+}
+
+// detect returns the ISO 639-1 code of the language text is written in, or
+// languageUnknown if it can't tell
+func (d *languageDetector) detect(text string) string {
+	if lang, ok := scriptLanguage(text); ok {
+		return lang
+	}
+
+	grams := trigrams(text)
+	if len(grams) < minLanguageTrigrams {
+		return languageUnknown
+	}
+
+	best, bestScore, secondScore := languageUnknown, math.Inf(-1), math.Inf(-1)
+	for lang, model := range d.models {
+		// Add-one smoothing over every trigram seen in any sample
+		score := 0.0
+		denominator := math.Log(float64(model.total + d.vocabulary))
+		for _, trigram := range grams {
+			score += math.Log(float64(model.counts[trigram]+1)) - denominator
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore, secondScore = lang, score, bestScore
+		case score > secondScore:
+			secondScore = score
+		}
+	}
+
+	if (bestScore-secondScore)/float64(len(grams)) < minLanguageMargin {
+		return languageUnknown
+	}
+	return best
+}
+
+// scriptLanguage returns the language of text if most of its letters are in
+// one of languageScripts. Text mostly in any other script, the Latin
+// alphabet included, isn't decided by it.
+func scriptLanguage(text string) (string, bool) {
+	letters := 0
+	counts := map[string]int{}
+	for _, c := range text {
+		if !unicode.IsLetter(c) {
+			continue
+		}
+		letters++
+		for _, s := range languageScripts {
+			if unicode.Is(s.script, c) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+
+	// Japanese mixes kana with Han characters
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+	for lang, n := range counts {
+		if n*2 > letters {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// trigrams returns the sequences of three characters in the lowercased
+// words of text, with each word padded by a space on both sides so that
+// beginnings and endings of words count
+func trigrams(text string) []string {
+	var grams []string
+	words := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && c != '\''
+	})
+	for _, word := range words {
+		runes := []rune(" " + strings.Trim(word, "'") + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			grams = append(grams, string(runes[i:i+3]))
+		}
+	}
+	return grams
+}
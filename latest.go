@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Number of reviews /reviews/latest returns by default and at most
+const (
+	defaultLatest = 10
+	maxLatest     = 50
+)
+
+// latestHandler handles fetching the n most recently submitted reviews,
+// newest first, for pages that only show a few. The reviews can be narrowed
+// down with the filter parameters like a listing, and n is capped at
+// maxLatest. Only the newest n are kept while scanning, instead of sorting
+// every matching review.
+func (s *Server) latestHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := queryInt(r, "n", defaultLatest)
+	if err != nil || n < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid n value. Must be a positive integer.")
+		return
+	}
+	if n > maxLatest {
+		n = maxLatest
+	}
+
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.visibleReviews(r, newestReviews(filter.apply(list), n)))
+}
+
+// newestReviews returns the n newest reviews in list, in the order
+// sortReviews puts them newest first
+func newestReviews(list []Review, n int) []Review {
+	newest := make([]Review, 0, n+1)
+	for _, review := range list {
+		if len(newest) == n && !newerReview(review, newest[n-1]) {
+			continue
+		}
+
+		// Insert the review in order, dropping the oldest if there are too
+		// many
+		i := sort.Search(len(newest), func(i int) bool { return newerReview(review, newest[i]) })
+		newest = append(newest, Review{})
+		copy(newest[i+1:], newest[i:])
+		newest[i] = review
+		if len(newest) > n {
+			newest = newest[:n]
+		}
+	}
+	return newest
+}
+
+// newerReview reports whether a was submitted after b, breaking ties by ID
+// like sortReviews
+func newerReview(a, b Review) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
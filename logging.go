@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Formats of the log output
+const (
+	logFormatText = "text" // key=value pairs, easy to read in a terminal
+	logFormatJSON = "json" // One JSON object per line, for log aggregation
+)
+
+// parseLogLevel parses a LOG_LEVEL value: debug, info, warn or error
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	switch strings.ToLower(value) {
+	case "debug", "info", "warn", "error":
+		err := level.UnmarshalText([]byte(value))
+		return level, err
+	}
+	return level, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", value)
+}
+
+// newLogger returns the logger configured by cfg, writing to standard error
+func newLogger(cfg Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// fatal logs msg at the error level with the given attributes and exits
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
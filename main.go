@@ -1,201 +1,130 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-// Review represents a review submitted by a user
-type Review struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	Review string `json:"review"`
-	Rating int    `json:"rating"` // New field to store the rating
-}
-
-// Slice to store reviews
-var reviews []Review
-
-// Mutex to synchronize access to the reviews slice
-var mutex = &sync.Mutex{}
-
-// Counter to generate unique IDs for reviews
-var idCounter = 0
-
-// File to persist reviews
-const reviewsFile = "reviews.json"
+// How long to wait for in-flight requests to finish when shutting down
+const shutdownTimeout = 10 * time.Second
 
 func main() {
-	// Load existing reviews from the file
-	loadReviews()
-
-	http.HandleFunc("/reviews", withCORS(reviewsHandler))
-	http.HandleFunc("/delete-review", withCORS(deleteReviewHandler)) // Handler for deleting a review
-
-	fmt.Println("Server is listening on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
-
-// withCORS is a middleware function that adds CORS headers
-func withCORS(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		
-		// Handle preflight OPTIONS request
-		if r.Method == http.MethodOptions {
-			return
-		}
-		
-		next(w, r)
-	}
-}
-
-// loadReviews loads reviews from the file at startup
-func loadReviews() {
-	file, err := ioutil.ReadFile(reviewsFile)
+	cfg, err := loadConfig()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// File doesn't exist, no reviews to load
-			reviews = []Review{}
-			return
-		}
-		log.Fatalf("Failed to load reviews: %v", err)
+		fatal("Invalid configuration", "error", err)
 	}
+	slog.SetDefault(newLogger(cfg))
 
-	// Parse JSON data into the reviews slice
-	err = json.Unmarshal(file, &reviews)
+	s, err := NewServer(cfg)
 	if err != nil {
-		log.Fatalf("Failed to parse reviews: %v", err)
+		fatal("Failed to start server", "error", err)
 	}
 
-	// Set the idCounter to the highest ID found
-	for _, review := range reviews {
-		if review.ID > idCounter {
-			idCounter = review.ID
-		}
+	if cfg.ReadOnly {
+		slog.Info("Server is in read-only mode; reviews can't be changed")
+	} else {
+		slog.Info("Server is in read-write mode")
 	}
-}
 
-// saveReviews saves the current reviews slice to a file
-func saveReviews() {
-	data, err := json.MarshalIndent(reviews, "", "  ")
-	if err != nil {
-		log.Printf("Failed to marshal reviews: %v", err)
-		return
-	}
-
-	err = ioutil.WriteFile(reviewsFile, data, 0644)
+	// Bring the reviews file up to the current schema, unless the file
+	// mustn't be written to, and make sure it can be read, and written
+	// unless the server is read-only, before accepting requests. Storage on
+	// a slow mount may not be ready straight away, so failures are retried.
+	var list []Review
+	err = retry(cfg.StartupRetries, cfg.StartupRetryDelay, func() error {
+		// A missing file holds no reviews, but a missing directory means
+		// the storage isn't there yet
+		if err := s.checkStorage(); err != nil {
+			return err
+		}
+		if !cfg.ReadOnly {
+			if err := s.store.checkWritable(); err != nil {
+				return err
+			}
+			if err := s.migrate(context.Background()); err != nil {
+				return fmt.Errorf("migrate: %w", err)
+			}
+		}
+		var err error
+		list, err = s.readReviews(context.Background())
+		return err
+	})
 	if err != nil {
-		log.Printf("Failed to write reviews to file: %v", err)
+		fatal("Failed to load reviews", "path", cfg.ReviewsFile, "error", err)
 	}
-}
+	s.metrics.setReviews(countUndeleted(list))
+	slog.Debug("Loaded reviews", "path", cfg.ReviewsFile, "count", len(list))
 
-// reviewsHandler handles both POST and GET requests for reviews
-func reviewsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		handlePostReview(w, r)
-	case http.MethodGet:
-		handleGetReviews(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !cfg.authEnabled() {
+		slog.Warn("No credentials are configured for AUTH_MODE, so write endpoints are unauthenticated and reviews awaiting moderation can't be listed", "auth_mode", cfg.AuthMode)
 	}
-}
 
-// handlePostReview handles the submission of a new review
-func handlePostReview(w http.ResponseWriter, r *http.Request) {
-	// Parse the JSON request body
-	var newReview Review
-	if err := json.NewDecoder(r.Body).Decode(&newReview); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
+	mux := http.NewServeMux()
+	s.routes(mux)
 
-	// Validate the rating value
-	if newReview.Rating < 1 || newReview.Rating > 5 {
-		http.Error(w, "Invalid rating value. Must be between 1 and 5.", http.StatusBadRequest)
-		return
+	// Log every request, whichever route handles it, and compress large
+	// responses. Panic recovery wraps the other middleware too, inside only
+	// the request ID so that panics are logged with it.
+	server := &http.Server{
+		Addr:     ":" + cfg.Port,
+		Handler:  withRequestID(withRecovery(s.withLogging(withGzip(withTimeout(s.withReadOnly(mux), cfg.RequestTimeout))))),
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
 	}
+	server.RegisterOnShutdown(s.events.close) // End event streams so shutdown doesn't wait on them
+
+	// Serve in the background so main can wait for a shutdown signal
+	go func() {
+		var err error
+		if cfg.useTLS() {
+			slog.Info("Server is listening for HTTPS", "port", cfg.Port)
+			err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			slog.Info("Server is listening for HTTP", "port", cfg.Port)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server failed", "error", err)
+		}
+	}()
 
-	// Lock the mutex before modifying the slice
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	// Assign a unique ID to the new review
-	idCounter++
-	newReview.ID = idCounter
-
-	reviews = append(reviews, newReview)
-
-	// Save reviews to the file
-	saveReviews()
-
-	// Respond with success and the assigned ID
-	response := map[string]interface{}{"success": true, "id": newReview.ID}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// handleGetReviews handles fetching all submitted reviews
-func handleGetReviews(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Lock the mutex before reading the slice
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	json.NewEncoder(w).Encode(reviews)
-}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
 
-// deleteReviewHandler handles the deletion of a review by ID
-func deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+	slog.Info("Shutting down")
 
-	// Parse the JSON request body to get the ID of the review to delete
-	var requestData struct {
-		ID int `json:"id"`
+	// Stop accepting connections and let in-flight requests finish. Any
+	// request that completes has already saved its changes to the file.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Graceful shutdown failed", "error", err)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
-
-	// Lock the mutex before modifying the slice
-	mutex.Lock()
-	defer mutex.Unlock()
+}
 
-	// Find and remove the review with the specified ID
-	index := -1
-	for i, review := range reviews {
-		if review.ID == requestData.ID {
-			index = i
-			break
+// Longest wait between two startup attempts
+const maxRetryDelay = 30 * time.Second
+
+// retry calls fn until it succeeds or has been retried retries times,
+// logging each failure. The wait after a failure starts at delay and doubles
+// every time, up to maxRetryDelay. It returns the last error.
+func retry(retries int, delay time.Duration, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries {
+			return err
 		}
-	}
 
-	if index == -1 {
-		http.Error(w, "Review not found", http.StatusNotFound)
-		return
+		slog.Warn("Startup attempt failed", "attempt", attempt, "attempts", retries+1, "error", err, "retry_in", delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
 	}
-
-	// Remove the review from the slice
-	reviews = append(reviews[:index], reviews[index+1:]...)
-
-	// Save reviews to the file
-	saveReviews()
-
-	// Respond with success
-	response := map[string]bool{"success": true}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
 }
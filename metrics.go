@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds, in seconds, of the request duration histogram buckets. These
+// are the Prometheus client defaults.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics collects request and review counts and serves them in the
+// Prometheus text exposition format
+type metrics struct {
+	mu sync.Mutex
+
+	requests map[requestKey]uint64 // Requests served, by method and status
+
+	durationCounts []uint64 // Requests per duration bucket, plus one for +Inf
+	durationSum    float64  // Total seconds spent serving requests
+	durationCount  uint64
+
+	reviews int // Stored reviews, as of the last read or write of the file
+}
+
+// requestKey labels the request counter
+type requestKey struct {
+	method string
+	status int
+}
+
+// newMetrics returns an empty metrics collector
+func newMetrics() *metrics {
+	return &metrics{
+		requests:       map[requestKey]uint64{},
+		durationCounts: make([]uint64, len(durationBuckets)+1),
+	}
+}
+
+// observeRequest records a served request
+func (m *metrics) observeRequest(method string, status int, elapsed time.Duration) {
+	// Bound the label values so odd methods can't create endless series
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+
+	seconds := elapsed.Seconds()
+	bucket := sort.SearchFloat64s(durationBuckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{method, status}]++
+	m.durationCounts[bucket]++
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// setReviews records the number of stored reviews that haven't been
+// deleted
+func (m *metrics) setReviews(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reviews = n
+}
+
+// metricsHandler serves the collected metrics for Prometheus to scrape
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP http_requests_total Total HTTP requests by method and status code.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "http_requests_total{method=%q,status=\"%d\"} %d\n", key.method, key.status, m.requests[key])
+	}
+
+	b.WriteString("# HELP http_request_duration_seconds Time spent serving HTTP requests.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, le := range durationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(&b, "http_request_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(&b, "http_request_duration_seconds_count %d\n", m.durationCount)
+
+	b.WriteString("# HELP reviews_total Number of stored reviews.\n")
+	b.WriteString("# TYPE reviews_total gauge\n")
+	fmt.Fprintf(&b, "reviews_total %d\n", m.reviews)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers browsers may send to the API from another origin
+var corsAllowedHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key", "If-None-Match", "X-API-Key", "X-Request-ID", "X-Validate-Only"}
+
+// withCORS is a middleware function that adds CORS headers and only lets
+// the given methods through, like allowMethods. The request's Origin is only
+// allowed if it is in the configured allowlist, unless the allowlist is the
+// "*" wildcard. Preflight requests are told the methods the route accepts
+// and which of the headers they asked for are allowed, and may be cached by
+// the browser for the configured max age.
+func (s *Server) withCORS(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	methods = append(methods[:len(methods):len(methods)], http.MethodOptions)
+	allowed := strings.Join(methods, ", ")
+	maxAge := strconv.Itoa(int(s.config.CORSMaxAge.Seconds()))
+	next = allowMethods(next, methods...)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := s.allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Methods", allowed)
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Expose-Headers", "Allow, Content-Disposition, ETag, Idempotent-Replayed, Location, Retry-After, WWW-Authenticate, X-Cache, X-Next-Cursor, X-RateLimit-Limit, X-RateLimit-Remaining, X-Request-ID, X-Total-Count")
+
+		// Handle preflight OPTIONS request
+		if r.Method == http.MethodOptions {
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", allowedRequestHeaders(requested))
+			}
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.Header().Set("Allow", allowed)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allowedRequestHeaders returns the headers in requested, the
+// comma-separated Access-Control-Request-Headers of a preflight request,
+// that are in corsAllowedHeaders. The browser blocks the request if it asked
+// for any other header.
+func allowedRequestHeaders(requested string) string {
+	var headers []string
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		for _, allowed := range corsAllowedHeaders {
+			if strings.EqualFold(header, allowed) {
+				headers = append(headers, header)
+				break
+			}
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+// allowedOrigin returns the value for the Access-Control-Allow-Origin header
+// for a request from origin, or an empty string if the origin isn't allowed
+func (s *Server) allowedOrigin(origin string) string {
+	for _, allowed := range s.config.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// statusRecorder wraps an http.ResponseWriter to remember the status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before passing it on
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Flush sends any buffered data to the client, so streaming handlers work
+// through the recorder
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over to the handler, for WebSockets. The
+// status is recorded as 101 Switching Protocols.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// withLogging is a middleware function that logs the method, path, status
+// code and duration of each request and records them in the metrics
+func (s *Server) withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start)
+		requestLogger(r).Info("Request served", "method", r.Method, "path", r.URL.Path, "status", rec.status, "duration_ms", float64(elapsed.Microseconds())/1000)
+		s.metrics.observeRequest(r.Method, rec.status, elapsed)
+	})
+}
+
+// Paths of streams that stay open indefinitely, exempt from the request
+// timeout
+var streamingPaths = map[string]bool{
+	"/reviews/stream": true,
+	"/ws":             true,
+}
+
+// withTimeout is a middleware function that cancels each request's context
+// after timeout, so storage calls made on its behalf give up. A handler still
+// running at the deadline, such as one waiting for the reviews file's lock,
+// is answered for with a 503 and whatever it writes afterwards is dropped,
+// unless it has started streaming its response already. Streaming endpoints
+// aren't limited.
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingPaths[unversionedPath(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		// Run the handler on its own goroutine so the deadline can be
+		// answered while it is blocked. A panic is passed back with the
+		// handler's stack to be re-raised here, where withRecovery can catch
+		// it.
+		tw := newTimeoutWriter(w)
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					if err != http.ErrAbortHandler {
+						err = handlerPanic{value: err, stack: debug.Stack()}
+					}
+					panicked <- err
+					return
+				}
+				close(done)
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		}()
+
+		select {
+		case err := <-panicked:
+			panic(err)
+		case <-done:
+			tw.finish()
+		case <-ctx.Done():
+			if tw.timeout() {
+				writeJSONError(w, http.StatusServiceUnavailable, "timeout", "Request timed out")
+				return
+			}
+			// Part of the response has been sent, so wait for the rest
+			select {
+			case err := <-panicked:
+				panic(err)
+			case <-done:
+			}
+		}
+	})
+}
+
+// withReadOnly is a middleware function that rejects mutating requests
+// (POST, PUT, PATCH and DELETE) with a 503 while the server is in read-only
+// mode, before any handler touches the reviews file
+func (s *Server) withReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.ReadOnly {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				writeJSONError(w, http.StatusServiceUnavailable, "read_only", "The server is in read-only mode for maintenance. Please try again later.")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlerPanic is a panic raised on another goroutine, such as the one
+// withTimeout runs the handler on, along with the stack where it was raised
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// withRecovery is a middleware function that turns a panic in any handler or
+// middleware it wraps into a logged stack trace and a 500 response, instead
+// of letting it kill the process
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// Deliberate abort; let net/http handle it as usual
+				panic(err)
+			}
+
+			stack := debug.Stack()
+			if p, ok := err.(handlerPanic); ok {
+				// Log where the handler panicked, not where it was re-raised
+				err, stack = p.value, p.stack
+			}
+			requestLogger(r).Error("Panic serving request", "method", r.Method, "path", r.URL.Path, "error", err, "stack", string(stack))
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// migration upgrades the stored reviews by one schema version. Reviews are
+// passed as generic JSON objects so a step can rename or reshape fields that
+// the Review struct no longer has, along with the server for steps that
+// depend on its configuration. Steps that change the document around the
+// reviews set applyDocument instead of apply.
+type migration struct {
+	version       int
+	description   string
+	apply         func(s *Server, records []map[string]interface{}) error
+	applyDocument func(s *Server, doc *rawDocument) error
+}
+
+// migrations lists every schema change in order. Append new steps to the end
+// with the next version number; never edit or reorder existing ones.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "store reviews in a versioned document",
+		apply:       func(s *Server, records []map[string]interface{}) error { return nil },
+	},
+	{
+		version:     2,
+		description: "approve reviews saved before moderation",
+		apply: func(s *Server, records []map[string]interface{}) error {
+			for _, record := range records {
+				if _, ok := record["status"]; !ok {
+					record["status"] = statusApproved
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "classify the sentiment of existing reviews",
+		apply: func(s *Server, records []map[string]interface{}) error {
+			for _, record := range records {
+				text, _ := record["review"].(string)
+				record["sentiment"] = defaultLexicon.classify(text)
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "detect the language of existing reviews",
+		apply: func(s *Server, records []map[string]interface{}) error {
+			for _, record := range records {
+				text, _ := record["review"].(string)
+				record["lang"] = defaultDetector.detect(text)
+			}
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "record the next review ID",
+		applyDocument: func(s *Server, doc *rawDocument) error {
+			// Purged reviews left no trace, so counting resumes above the
+			// highest remaining ID
+			doc.NextID = 1
+			for _, record := range doc.Reviews {
+				number, _ := record["id"].(json.Number)
+				if id, err := number.Int64(); err == nil && int(id) >= doc.NextID {
+					doc.NextID = int(id) + 1
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     6,
+		description: "classify the sentiment of existing reviews with the configured lexicon",
+		apply: func(s *Server, records []map[string]interface{}) error {
+			// Migration 3 used the built-in lexicon whatever SENTIMENT_FILE
+			// said; classify every review again like a new one
+			for _, record := range records {
+				text, _ := record["review"].(string)
+				record["sentiment"] = s.sentiment.classify(s.plainText(text))
+			}
+			return nil
+		},
+	},
+	{
+		version:     7,
+		description: "detect the language of existing reviews as submitted",
+		apply: func(s *Server, records []map[string]interface{}) error {
+			// Migration 4 looked at the text as escaped for storage, where
+			// entities such as &#39; read as words
+			for _, record := range records {
+				text, _ := record["review"].(string)
+				record["lang"] = defaultDetector.detect(s.plainText(text))
+			}
+			return nil
+		},
+	},
+}
+
+// schemaVersion is the version of the reviews file this server reads and
+// writes
+var schemaVersion = migrations[len(migrations)-1].version
+
+// rawDocument is the reviews file decoded without assuming a schema
+type rawDocument struct {
+	SchemaVersion int                      `json:"schema_version"`
+	NextID        int                      `json:"next_id,omitempty"` // Missing before version 5
+	Reviews       []map[string]interface{} `json:"reviews"`
+}
+
+// migrate brings the stored reviews up to schemaVersion, applying each
+// newer migration in order. The steps run on an in-memory copy which
+// replaces the stored reviews only after all of them succeed, so a failure
+// leaves them untouched. It also picks up the ID counter saved with the
+// reviews, so IDs handed out before a restart aren't reused.
+func (s *Server) migrate(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.store.loadDocument(ctx)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		// Nothing to migrate; the reviews are saved at the current version
+		return nil
+	}
+
+	doc, err := parseRawDocument(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", s.store.location(), err)
+	}
+	if doc.SchemaVersion > schemaVersion {
+		return fmt.Errorf("%s has schema version %d, newer than the supported %d", s.store.location(), doc.SchemaVersion, schemaVersion)
+	}
+	if doc.SchemaVersion == schemaVersion {
+		s.countNextID(doc.NextID)
+		return nil
+	}
+
+	from := doc.SchemaVersion
+	for _, m := range migrations {
+		if m.version <= doc.SchemaVersion {
+			continue
+		}
+		var err error
+		if m.applyDocument != nil {
+			err = m.applyDocument(s, &doc)
+		} else {
+			err = m.apply(s, doc.Reviews)
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		doc.SchemaVersion = m.version
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.store.saveDocument(ctx, data); err != nil {
+		return err
+	}
+	s.countNextID(doc.NextID)
+
+	slog.Info("Migrated reviews file", "path", s.store.location(), "from_version", from, "to_version", doc.SchemaVersion)
+	return nil
+}
+
+// countNextID raises the ID counter to just below nextID, the next_id of
+// the reviews file. The caller must hold the mutex for writing.
+func (s *Server) countNextID(nextID int) {
+	if nextID-1 > s.idCounter {
+		s.idCounter = nextID - 1
+	}
+}
+
+// parseRawDocument decodes the reviews file. Files written before the
+// schema was versioned hold a bare array of reviews and count as version 0.
+func parseRawDocument(data []byte) (rawDocument, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // Keep IDs and ratings exactly as written
+
+	var doc rawDocument
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := dec.Decode(&doc.Reviews)
+		return doc, err
+	}
+	err := dec.Decode(&doc)
+	return doc, err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Moderation statuses of a review. New reviews are pending and only approved
+// ones are shown publicly.
+const (
+	statusPending  = "pending"
+	statusApproved = "approved"
+	statusRejected = "rejected"
+)
+
+// Moderation actions and the status each one sets
+var moderationActions = map[string]string{
+	"approve": statusApproved,
+	"reject":  statusRejected,
+}
+
+// errReviewNotFound is returned when no review has the requested ID
+var errReviewNotFound = errors.New("review not found")
+
+// validStatus reports whether status is one of the moderation statuses
+func validStatus(status string) bool {
+	switch status {
+	case statusPending, statusApproved, statusRejected:
+		return true
+	}
+	return false
+}
+
+// handleModerateReview handles /reviews/{id}/approve and
+// /reviews/{id}/reject, which set the moderation status of a review. Only
+// admins may moderate, and nobody may when no credentials are configured.
+func (s *Server) handleModerateReview(w http.ResponseWriter, r *http.Request, id int, action string) {
+	status, ok := moderationActions[action]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.allowAdmin(w, r) {
+		return
+	}
+
+	review, err := s.setStatus(r.Context(), id, status)
+	if errors.Is(err, errReviewNotFound) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Review not found")
+		return
+	}
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.visibleReview(r, review))
+}
+
+// setStatus sets the moderation status of the review with the given ID,
+// saves it and notifies event subscribers. It returns errReviewNotFound if
+// there is no such review.
+func (s *Server) setStatus(ctx context.Context, id int, status string) (Review, error) {
+	// Lock the mutex before modifying the reviews
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list, err := s.readReviews(ctx)
+	if err != nil {
+		return Review{}, err
+	}
+	review := findReview(list, id)
+	if review == nil {
+		return Review{}, errReviewNotFound
+	}
+	review.Status = status
+
+	// Save reviews to the file
+	if err := s.writeReviews(ctx, list); err != nil {
+		return Review{}, err
+	}
+	s.productStats.invalidate(review.ProductID)
+	s.events.publish(*review)
+	return *review, nil
+}
+
+// handleVerifyReview handles /reviews/{id}/verify, which marks a review as
+// written by a confirmed buyer. Only admins may verify reviews, like
+// moderating them.
+func (s *Server) handleVerifyReview(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.allowAdmin(w, r) {
+		return
+	}
+
+	review, ok := s.modifyReview(w, r, id, func(review *Review) *apiError {
+		review.Verified = true
+		return nil
+	})
+	if ok {
+		writeJSON(w, http.StatusOK, s.visibleReview(r, review))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestModerationRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.submit(t, "Ann", "Fine")
+
+	for _, action := range []string{"approve", "reject"} {
+		path := fmt.Sprintf("/reviews/%d/%s", review.ID, action)
+		if w := ts.do(t, http.MethodPost, path, nil, anonymous()); w.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s without credentials: got %d, want %d", path, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if got := ts.storedReview(t, review.ID).Status; got != statusPending {
+		t.Fatalf("got status %q, want %q", got, statusPending)
+	}
+
+	w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/approve", review.ID), nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("approve with the API key: got %d %s", w.Code, w.Body)
+	}
+	if got := ts.storedReview(t, review.ID).Status; got != statusApproved {
+		t.Errorf("got status %q, want %q", got, statusApproved)
+	}
+}
+
+func TestModerationDisabledWithoutCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	review := ts.submit(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d", review.ID)
+
+	if w := ts.do(t, http.MethodPost, path+"/approve", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("approve: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := ts.do(t, http.MethodGet, path, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET pending review: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPendingReviewsListedForAdmins(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.submit(t, "Ann", "Fine")
+
+	if w := ts.do(t, http.MethodGet, "/reviews?status=pending", nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w := ts.do(t, http.MethodGet, "/reviews?status=pending", nil, nil)
+	var list []Review
+	decodeBody(t, w, &list)
+	if len(list) != 1 {
+		t.Errorf("with the API key: got %d reviews, want 1", len(list))
+	}
+	w = ts.do(t, http.MethodGet, "/reviews", nil, anonymous())
+	decodeBody(t, w, &list)
+	if len(list) != 0 {
+		t.Errorf("public listing: got %d reviews, want 0", len(list))
+	}
+}
+
+func TestVerifyRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d/verify", review.ID)
+
+	if w := ts.do(t, http.MethodPost, path, nil, anonymous()); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if ts.storedReview(t, review.ID).Verified {
+		t.Fatal("review verified without credentials")
+	}
+
+	if w := ts.do(t, http.MethodPost, path, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("with the API key: got %d %s", w.Code, w.Body)
+	}
+	w := ts.do(t, http.MethodGet, "/reviews?verified=true", nil, anonymous())
+	var list []Review
+	decodeBody(t, w, &list)
+	if len(list) != 1 || !list[0].Verified {
+		t.Errorf("verified listing: got %+v, want the verified review", list)
+	}
+}
+
+func TestVerifyDisabledWithoutCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"API_KEY": ""})
+	review := ts.submit(t, "Ann", "Fine")
+
+	if w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/verify", review.ID), nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if ts.storedReview(t, review.ID).Verified {
+		t.Error("review verified without credentials configured")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Media type of JSON Lines responses: one JSON value per line
+const ndjsonContentType = "application/x-ndjson"
+
+// How many lines are written between flushes of an NDJSON response
+const ndjsonFlushLines = 100
+
+// wantsNDJSON reports whether r asks for a listing as JSON Lines, with
+// format=ndjson or, when format isn't given, an Accept header naming
+// application/x-ndjson
+func wantsNDJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "ndjson"
+	}
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// writeNDJSON writes items as a JSON Lines response, flushing every
+// ndjsonFlushLines lines so the client can process them as they arrive. An
+// error once the response has started can't be reported to the client, so
+// it is logged and the response cut short.
+func writeNDJSON(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, item := range items {
+		if err := enc.Encode(item); err != nil {
+			requestLogger(r).Warn("Failed to write NDJSON response", "error", err)
+			return
+		}
+		if flusher != nil && (i+1)%ndjsonFlushLines == 0 {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "sync"
+
+// productStatsCache holds the stats of the approved reviews of each product,
+// as served by /reviews/stats?product_id=..., so product pages don't average
+// every review on each load. Entries are computed on first use and
+// invalidated whenever a review of their product changes. Invalidation
+// happens with the server's write lock held and entries are computed with
+// the read lock held, so an entry can't be computed from reviews that a
+// concurrent write has just replaced.
+type productStatsCache struct {
+	mu    sync.Mutex
+	stats map[string]reviewStats
+}
+
+// newProductStatsCache returns an empty cache
+func newProductStatsCache() *productStatsCache {
+	return &productStatsCache{stats: map[string]reviewStats{}}
+}
+
+// get returns the cached stats of product, if any
+func (c *productStatsCache) get(product string) (reviewStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.stats[product]
+	return stats, ok
+}
+
+// put caches the stats of product
+func (c *productStatsCache) put(product string, stats reviewStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats[product] = stats
+}
+
+// invalidate forgets the stats of the given products
+func (c *productStatsCache) invalidate(products ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, product := range products {
+		delete(c.stats, product)
+	}
+}
+
+// invalidateAll forgets the stats of every product, for changes that may
+// touch any review
+func (c *productStatsCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = map[string]reviewStats{}
+}
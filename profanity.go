@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Ways of handling a review that contains a listed word
+const (
+	profanityReject = "reject" // Refuse the review with a 400
+	profanityMask   = "mask"   // Replace the word's letters with asterisks
+)
+
+// profanityFilter finds listed words in review text. Matching ignores case
+// and only considers whole words, so "classic" doesn't match "ass".
+type profanityFilter struct {
+	words map[string]bool
+	mode  string
+}
+
+// loadProfanityFilter reads a word list from path, one word per line. Blank
+// lines and lines starting with # are ignored.
+func loadProfanityFilter(path, mode string) (*profanityFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	filter := &profanityFilter{words: map[string]bool{}, mode: mode}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		filter.words[word] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// contains reports whether text contains a listed word
+func (f *profanityFilter) contains(text string) bool {
+	found := false
+	eachWord(text, func(start, end int) {
+		if f.words[strings.ToLower(text[start:end])] {
+			found = true
+		}
+	})
+	return found
+}
+
+// mask returns text with every listed word replaced by asterisks
+func (f *profanityFilter) mask(text string) string {
+	var b strings.Builder
+	last := 0
+	eachWord(text, func(start, end int) {
+		word := text[start:end]
+		if f.words[strings.ToLower(word)] {
+			b.WriteString(text[last:start])
+			b.WriteString(strings.Repeat("*", len([]rune(word))))
+			last = end
+		}
+	})
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// eachWord calls fn with the byte offsets of each run of letters and digits
+// in text
+func eachWord(text string, fn func(start, end int)) {
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			fn(start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		fn(start, len(text))
+	}
+}
+
+// filterProfanity applies the profanity filter, if one is configured, to the
+// review's name and text. It masks listed words in place, or returns a
+// message if the review should be rejected instead.
+func (s *Server) filterProfanity(review *Review) string {
+	if s.profanity == nil {
+		return ""
+	}
+
+	if s.profanity.mode == profanityMask {
+		review.Name = s.profanity.mask(review.Name)
+		review.Review = s.profanity.mask(review.Review)
+		return ""
+	}
+
+	if s.profanity.contains(review.Name) || s.profanity.contains(review.Review) {
+		return "Review contains language that isn't allowed"
+	}
+	return ""
+}
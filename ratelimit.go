@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token-bucket limiter keyed by client. Each client may
+// make up to burst requests at once, refilled at rate per second.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	clients map[string]*bucket
+}
+
+// bucket tracks the tokens left for one client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter that allows perMinute requests per client
+// per minute
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		clients: map[string]*bucket{},
+	}
+}
+
+// allow takes a token for key if one is available and reports how many
+// whole tokens are left. Otherwise it reports how long until the next one
+// is.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.clients[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[key] = b
+	}
+
+	// Refill for the time since the client was last seen
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// cleanup forgets clients whose buckets have refilled completely, since they
+// would start from a full bucket anyway
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.clients {
+		if now.Sub(b.last) >= full {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// runCleanup calls cleanup every interval so the client map doesn't grow
+// without bound
+func (l *rateLimiter) runCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		l.cleanup(now)
+	}
+}
+
+// checkRateLimit applies the review submission limiter, if one is
+// configured, to the client making r. If the client is over its limit it
+// writes a 429 with a Retry-After header and returns false.
+func (s *Server) checkRateLimit(w http.ResponseWriter, r *http.Request) bool {
+	return s.limitClient(w, r, s.limiter, "Too many reviews submitted. Please try again later.")
+}
+
+// limitClient implements checkRateLimit for the limiter l, which is
+// unlimited when nil, using message in the 429 response. Every limited
+// response tells the client its budget in the X-RateLimit-Limit and
+// X-RateLimit-Remaining headers, so it can slow down before reaching it.
+func (s *Server) limitClient(w http.ResponseWriter, r *http.Request, l *rateLimiter, message string) bool {
+	if l == nil {
+		return true
+	}
+
+	ok, remaining, wait := l.allow(clientIP(r, s.config.TrustProxy), time.Now())
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(l.burst)))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !ok {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		apiErr := newAPIError(http.StatusTooManyRequests, "rate_limited", message)
+		apiErr.Detail = fmt.Sprintf("Each client may make %d of these requests per minute. Try again in %d seconds.", int(l.burst), retryAfter)
+		apiErr.RetryAfter = retryAfter
+		writeJSON(w, apiErr.Status, apiErr)
+		return false
+	}
+	return true
+}
+
+// clientIP returns the IP address of the client making r, normalized by
+// normalizeIP. When trustProxy is set the first address in X-Forwarded-For
+// is used, as set by a reverse proxy in front of the server.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return normalizeIP(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return normalizeIP(r.RemoteAddr)
+}
+
+// normalizeIP strips any port from addr and writes the address in its
+// canonical form, so that IPv6 addresses compare equal however they were
+// written and IPv4-mapped IPv6 addresses become plain IPv4. An address that
+// doesn't parse is returned trimmed but otherwise as it is.
+func normalizeIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
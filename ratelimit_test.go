@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter(60)
+	now := time.Now()
+	for i := 0; i < 60; i++ {
+		if ok, _, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	ok, remaining, wait := l.allow("a", now)
+	if ok || remaining != 0 || wait != time.Second {
+		t.Fatalf("past the burst: got %v with %d left and a wait of %v, want a refusal and a wait of 1s", ok, remaining, wait)
+	}
+	if ok, _, _ := l.allow("b", now); !ok {
+		t.Error("another client was refused")
+	}
+	if ok, remaining, _ := l.allow("a", now.Add(time.Second)); !ok || remaining != 0 {
+		t.Errorf("after a second: got %v with %d left, want one more request", ok, remaining)
+	}
+}
+
+func TestSubmissionsAreRateLimited(t *testing.T) {
+	// Without credentials configured anyone may submit, so the limit is all
+	// that holds back a flood
+	ts := newTestServer(t, map[string]string{"API_KEY": "", "RATE_LIMIT_PER_MINUTE": "2", "TRUST_PROXY": "true"})
+	submit := func(ip, text string) *http.Response {
+		t.Helper()
+		body := map[string]interface{}{"name": "Ann", "review": text, "rating": 4, "product_id": "p1"}
+		return ts.do(t, http.MethodPost, "/reviews", body, reader(ip)).Result()
+	}
+
+	for i, text := range []string{"Fine", "Good"} {
+		res := submit("192.0.2.1", text)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("submission %d: got %d", i+1, res.StatusCode)
+		}
+		if limit, remaining := res.Header.Get("X-RateLimit-Limit"), res.Header.Get("X-RateLimit-Remaining"); limit != "2" || remaining != strconv.Itoa(1-i) {
+			t.Errorf("submission %d: got limit %q and %q remaining, want 2 and %d", i+1, limit, remaining, 1-i)
+		}
+	}
+
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": "Ann", "review": "Great", "rating": 4, "product_id": "p1"}, reader("192.0.2.1"))
+	var apiErr apiError
+	decodeBody(t, w, &apiErr)
+	if w.Code != http.StatusTooManyRequests || apiErr.Code != "rate_limited" || apiErr.Detail == "" {
+		t.Fatalf("past the limit: got %d %+v", w.Code, apiErr)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter == "" || retryAfter != strconv.Itoa(apiErr.RetryAfter) {
+		t.Errorf("got Retry-After %q and retry_after %d, want them to agree", retryAfter, apiErr.RetryAfter)
+	}
+	if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("past the limit: got %q remaining, want 0", remaining)
+	}
+
+	// Each client has its own budget
+	if res := submit("192.0.2.2", "Great"); res.StatusCode != http.StatusCreated {
+		t.Errorf("another client: got %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"RATE_LIMIT_PER_MINUTE": "0"})
+	w := ts.do(t, http.MethodPost, "/reviews", map[string]interface{}{"name": "Ann", "review": "Fine", "rating": 4, "product_id": "p1"}, nil)
+	if w.Code != http.StatusCreated || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("got %d with X-RateLimit-Limit %q, want %d without it", w.Code, w.Header().Get("X-RateLimit-Limit"), http.StatusCreated)
+	}
+}
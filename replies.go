@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Errors from posting a reply with an unusable parent_id
+var (
+	errParentNotFound = errors.New("parent review not found")
+	errNestedReply    = errors.New("replies to replies are not allowed")
+)
+
+// threadedReview is a top-level review with its replies nested under it, as
+// listed with replies=nested
+type threadedReview struct {
+	Review
+	Replies []Review `json:"replies"` // Oldest first
+}
+
+// checkParent validates the parent of a reply against the stored reviews:
+// it must be an approved review that isn't a reply itself. The reply is
+// about the same product as its parent.
+func checkParent(list []Review, reply *Review) error {
+	parent := findReview(list, *reply.ParentID)
+	if parent == nil || parent.Status != statusApproved {
+		return errParentNotFound
+	}
+	if parent.ParentID != nil {
+		return errNestedReply
+	}
+	reply.ProductID = parent.ProductID
+	return nil
+}
+
+// splitReplies separates the top-level reviews in list from the replies,
+// which are grouped by parent ID in oldest first order. The order of the
+// top-level reviews is kept.
+func splitReplies(list []Review) ([]Review, map[int][]Review) {
+	top := []Review{}
+	replies := map[int][]Review{}
+	for _, review := range list {
+		if review.ParentID == nil {
+			top = append(top, review)
+		} else {
+			replies[*review.ParentID] = append(replies[*review.ParentID], review)
+		}
+	}
+	for _, thread := range replies {
+		sortReviews(thread, false)
+	}
+	return top, replies
+}
+
+// nestReplies attaches to each review in page its replies from the map
+// built by splitReplies, as the client making r may see them
+func (s *Server) nestReplies(r *http.Request, page []Review, replies map[int][]Review) []threadedReview {
+	threads := make([]threadedReview, len(page))
+	for i, review := range page {
+		threads[i] = threadedReview{Review: s.visibleReview(r, review), Replies: s.visibleReviews(r, replies[review.ID])}
+	}
+	return threads
+}
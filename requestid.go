@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Header carrying the request's correlation ID, in both directions
+const requestIDHeader = "X-Request-ID"
+
+// Longest incoming request ID that is kept; longer ones are replaced
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key for the request ID
+type requestIDKey struct{}
+
+// withRequestID is a middleware function that gives each request a
+// correlation ID: the caller's X-Request-ID header if it is usable,
+// otherwise a new UUID. The ID is stored in the request context for logging
+// and echoed in the X-Request-ID response header, where writeJSON also finds
+// it for error responses.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the request ID stored in ctx, or "-" if there is none
+func requestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return "-"
+}
+
+// validRequestID reports whether an incoming request ID is safe to log and
+// echo: non-empty, not too long, and printable ASCII without spaces
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random version 4 UUID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0F | 0x40 // Version 4
+	b[8] = b[8]&0x3F | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestLogger returns a logger for messages about r, which tags them with
+// its request ID
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With("request_id", requestID(r.Context()))
+}
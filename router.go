@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowMethods is a middleware function that answers requests with any
+// method other than the given ones with a 405, so each route declares the
+// methods it accepts where it is registered. Handlers behind it only need to
+// tell the allowed methods apart.
+func allowMethods(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowsMethod(methods, r.Method) {
+			methodNotAllowed(w, methods...)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allowsMethod reports whether method is one of methods
+func allowsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// methodNotAllowed writes a 405 response whose Allow header lists the
+// methods the resource accepts
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// When review names and text are HTML-escaped. Escaping is a safety net for
+// frontends that insert reviews into a page as HTML; clients should still
+// treat all review content as untrusted and escape it for wherever they
+// display it.
+const (
+	escapeOnWrite = "write" // Escape before saving, so the file holds escaped text
+	escapeOnRead  = "read"  // Save text as submitted and escape it in responses
+	escapeOff     = "off"   // Never escape
+)
+
+// storedText returns user-supplied text as it is saved: HTML-escaped if the
+// server escapes on write, otherwise unchanged
+func (s *Server) storedText(text string) string {
+	if s.config.HTMLEscape == escapeOnWrite {
+		return html.EscapeString(text)
+	}
+	return text
+}
+
+// plainText returns stored text as it was submitted, undoing storedText,
+// for output formats that do their own escaping
+func (s *Server) plainText(text string) string {
+	if s.config.HTMLEscape == escapeOnWrite {
+		return html.UnescapeString(text)
+	}
+	return text
+}
+
+// stripControl removes control characters such as null bytes from
+// user-supplied text, since they break rendering and downstream consumers.
+// Multi-line text keeps its newlines and tabs; carriage returns are dropped,
+// so CRLF line endings from browser forms are saved as plain newlines.
+func stripControl(text string, multiline bool) string {
+	return strings.Map(func(r rune) rune {
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// escapeForStorage applies storedText to the name and text of a review
+// submitted by a client, and clears the computed lengths so they aren't
+// saved
+func (s *Server) escapeForStorage(review *Review) {
+	review.Name = s.storedText(review.Name)
+	review.Review = s.storedText(review.Review)
+	review.CharCount = 0
+	review.WordCount = 0
+}
+
+// escapeForResponse HTML-escapes the name, text and flag reasons of a review
+// being sent to a client if the server escapes on read
+func (s *Server) escapeForResponse(review *Review) {
+	if s.config.HTMLEscape == escapeOnRead {
+		review.Name = html.EscapeString(review.Name)
+		review.Review = html.EscapeString(review.Review)
+
+		// Copy the reports so the caller's review is left as it was
+		reports := append([]flagReport(nil), review.FlagReports...)
+		for i := range reports {
+			reports[i].Reason = html.EscapeString(reports[i].Reason)
+		}
+		review.FlagReports = reports
+	}
+}
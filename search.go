@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// BM25 tuning parameters, at their customary values
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// searchHandler handles full-text search over reviews at
+// /reviews/search?q=. A review matches when its name or text contains every
+// word of the query, and matches are ranked by BM25 relevance, best first.
+// The number of results is capped by the limit query parameter.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	terms := tokenize(r.URL.Query().Get("q"))
+	if len(terms) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Missing search query. Use the q parameter.")
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultLimit)
+	if err != nil || limit < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "Invalid limit value. Must be a positive integer.")
+		return
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	// Only approved reviews are searchable
+	list = reviewFilter{status: statusApproved}.apply(list)
+	results := s.rankReviews(list, terms)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	writeJSON(w, http.StatusOK, s.visibleReviews(r, results))
+}
+
+// rankReviews returns the reviews containing every term, ordered by BM25
+// score. Names and text are searched as they were submitted, not as escaped
+// for storage. The result is never nil so it encodes as an empty array.
+func (s *Server) rankReviews(list []Review, terms []string) []Review {
+	// Term frequencies and lengths of every review, for the corpus statistics
+	docs := make([]map[string]int, len(list))
+	lengths := make([]int, len(list))
+	docFreq := map[string]int{}
+	totalLength := 0
+	for i, review := range list {
+		words := tokenize(s.plainText(review.Name) + " " + s.plainText(review.Review))
+		docs[i] = map[string]int{}
+		for _, word := range words {
+			docs[i][word]++
+		}
+		for word := range docs[i] {
+			docFreq[word]++
+		}
+		lengths[i] = len(words)
+		totalLength += len(words)
+	}
+
+	type match struct {
+		review Review
+		score  float64
+	}
+	matches := []match{}
+	n := float64(len(list))
+	avgLength := float64(totalLength) / math.Max(n, 1)
+
+	for i, review := range list {
+		score := 0.0
+		for _, term := range terms {
+			tf := float64(docs[i][term])
+			if tf == 0 {
+				score = -1
+				break
+			}
+			df := float64(docFreq[term])
+			idf := math.Log((n-df+0.5)/(df+0.5) + 1)
+			norm := 1 - bm25B + bm25B*float64(lengths[i])/avgLength
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		if score >= 0 {
+			matches = append(matches, match{review, score})
+		}
+	}
+
+	// Best match first; equal scores keep the newest review first
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].review.ID > matches[j].review.ID
+	})
+
+	results := make([]Review, len(matches))
+	for i, m := range matches {
+		results[i] = m.review
+	}
+	return results
+}
+
+// tokenize splits text into lowercase words
+func tokenize(text string) []string {
+	var words []string
+	eachWord(text, func(start, end int) {
+		words = append(words, strings.ToLower(text[start:end]))
+	})
+	return words
+}
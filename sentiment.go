@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sentiment classes of a review
+const (
+	sentimentPositive = "positive"
+	sentimentNeutral  = "neutral"
+	sentimentNegative = "negative"
+)
+
+// Lexicon used when no SENTIMENT_FILE is configured, and as the base that
+// one extends
+//
+//go:embed sentiment_lexicon.txt
+var defaultLexiconText string
+
+// defaultLexicon is defaultLexiconText parsed
+var defaultLexicon = mustParseLexicon(defaultLexiconText)
+
+// Words that flip the score of the word after them, as in "not good". The
+// "t" covers contractions such as "isn't", which tokenize splits in two.
+var negators = map[string]bool{"not": true, "no": true, "never": true, "t": true}
+
+// sentimentLexicon maps words to scores. It classifies text by adding up the
+// scores of its words; it is a deterministic heuristic, not a model.
+type sentimentLexicon map[string]int
+
+// loadSentimentLexicon returns the default lexicon extended with the words
+// in the file at path, whose scores take precedence
+func loadSentimentLexicon(path string) (sentimentLexicon, error) {
+	lexicon := sentimentLexicon{}
+	for word, score := range defaultLexicon {
+		lexicon[word] = score
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := lexicon.parse(file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lexicon, nil
+}
+
+// mustParseLexicon parses a lexicon known to be valid
+func mustParseLexicon(text string) sentimentLexicon {
+	lexicon := sentimentLexicon{}
+	if err := lexicon.parse(strings.NewReader(text)); err != nil {
+		panic(err)
+	}
+	return lexicon
+}
+
+// parse adds the words read from r to the lexicon, one "word score" pair per
+// line. Blank lines and lines starting with # are ignored.
+func (l sentimentLexicon) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: want a word and a score", line)
+		}
+		score, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: invalid score %q", line, fields[1])
+		}
+		l[strings.ToLower(fields[0])] = score
+	}
+	return scanner.Err()
+}
+
+// classify returns the sentiment of text: positive if its word scores add up
+// to more than zero, negative if to less, and neutral otherwise
+func (l sentimentLexicon) classify(text string) string {
+	total := 0
+	negate := false
+	for _, word := range tokenize(text) {
+		if negators[word] {
+			negate = true
+			continue
+		}
+		if score, ok := l[word]; ok {
+			if negate {
+				score = -score
+			}
+			total += score
+			negate = false
+		}
+	}
+
+	switch {
+	case total > 0:
+		return sentimentPositive
+	case total < 0:
+		return sentimentNegative
+	}
+	return sentimentNeutral
+}
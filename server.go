@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Review represents a review submitted by a user
+type Review struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Anonymous bool      `json:"anonymous"` // Whether the author left out their name, which was replaced by the configured default
+	Review    string    `json:"review"`
+	Rating    int       `json:"rating"`              // New field to store the rating
+	CreatedAt time.Time `json:"created_at"`          // Submission time; zero for reviews saved before it was tracked
+	ProductID string    `json:"product_id"`          // Product the review is about; empty for reviews saved before it was tracked
+	Email     string    `json:"email,omitempty"`     // Author's address; optional, and only shown to admins
+	Status    string    `json:"status"`              // Moderation status: pending, approved or rejected
+	Upvotes   int       `json:"upvotes"`             // Number of readers who found the review helpful
+	Downvotes int       `json:"downvotes"`           // Number of readers who didn't
+	ParentID  *int      `json:"parent_id,omitempty"` // Review this one replies to; nil for top-level reviews
+	Sentiment string    `json:"sentiment"`           // Positive, neutral or negative, classified from the text
+	Lang      string    `json:"lang"`                // ISO 639-1 code of the language of the text, or "unknown"
+	IP        string    `json:"ip,omitempty"`        // Address the review was submitted from; only shown to admins
+	Verified  bool      `json:"verified"`            // Whether the author is a confirmed buyer
+	Flags     int       `json:"flags"`               // Number of readers who reported the review as inappropriate
+	Tags      []string  `json:"tags"`                // Lowercase topics such as "shipping"; nil for reviews saved before they were tracked
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // When the review was deleted; nil unless it was. Deleted reviews are kept but hidden.
+
+	// Length of the text in characters and words, computed for responses
+	// and never stored
+	CharCount int `json:"char_count,omitempty"`
+	WordCount int `json:"word_count,omitempty"`
+
+	FlagReports []flagReport `json:"flag_reports,omitempty"` // Who flagged the review and why; only shown to admins
+}
+
+// public returns a copy of the review without the fields that only admins
+// may see
+func (review Review) public() Review {
+	review.Email = ""
+	review.IP = ""
+	review.FlagReports = nil
+	return review
+}
+
+// How often the rate limiter forgets idle clients and the idempotency store
+// forgets expired keys
+const (
+	rateLimitCleanupInterval   = 5 * time.Minute
+	idempotencyCleanupInterval = 5 * time.Minute
+)
+
+// Server holds the state shared by the review handlers
+type Server struct {
+	config Config
+
+	// Where the reviews are persisted
+	store reviewStore
+
+	// Mutex to synchronize access to the reviews file. Readers take the read
+	// lock; handlers that modify reviews hold the write lock across the whole
+	// read-modify-write.
+	mutex sync.RWMutex
+
+	// Counter to generate unique IDs for reviews: the highest ID handed out.
+	// It only ever grows, so an ID is not reused after the review holding it
+	// is deleted or purged. It is saved with the reviews as next_id and
+	// picked up from the file by migrate. Guarded by the write lock.
+	idCounter int
+
+	// Filter for submitted reviews, or nil if none is configured
+	profanity *profanityFilter
+
+	// Word scores for classifying the sentiment of reviews
+	sentiment sentimentLexicon
+
+	// Limiters for review submissions, flags and votes per client, or nil
+	// if unlimited
+	limiter     *rateLimiter
+	flagLimiter *rateLimiter
+	voteLimiter *rateLimiter
+
+	// Request and review counts exposed at /metrics
+	metrics *metrics
+
+	// Recent listing responses, or nil if caching is disabled
+	cache *lruCache
+
+	// Stats of the approved reviews of each product
+	productStats *productStatsCache
+
+	// Subscribers to /reviews/stream, notified of new and approved reviews
+	events *broadcaster
+
+	// Webhooks notified of new reviews
+	webhooks *webhookNotifier
+
+	// Reviews created by requests with an Idempotency-Key, by key
+	idempotency *idempotencyStore
+}
+
+// NewServer returns a Server configured by cfg, loading any files the
+// configuration refers to
+func NewServer(cfg Config) (*Server, error) {
+	s := &Server{config: cfg, metrics: newMetrics(), events: newBroadcaster(), sentiment: defaultLexicon, productStats: newProductStatsCache()}
+	store, err := newReviewStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.store = store
+	s.idempotency = newIdempotencyStore(cfg.IdempotencyTTL)
+	s.webhooks = newWebhookNotifier(cfg.WebhookURLs, cfg.WebhookTimeout)
+	go s.idempotency.runCleanup(idempotencyCleanupInterval)
+
+	if cfg.ProfanityFile != "" {
+		filter, err := loadProfanityFilter(cfg.ProfanityFile, cfg.ProfanityMode)
+		if err != nil {
+			return nil, fmt.Errorf("load profanity list: %w", err)
+		}
+		s.profanity = filter
+	}
+
+	if cfg.SentimentFile != "" {
+		lexicon, err := loadSentimentLexicon(cfg.SentimentFile)
+		if err != nil {
+			return nil, fmt.Errorf("load sentiment lexicon: %w", err)
+		}
+		s.sentiment = lexicon
+	}
+
+	if cfg.CacheSize > 0 {
+		s.cache = newLRUCache(cfg.CacheSize)
+	}
+
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit)
+		go s.limiter.runCleanup(rateLimitCleanupInterval)
+	}
+	if cfg.FlagRateLimit > 0 {
+		s.flagLimiter = newRateLimiter(cfg.FlagRateLimit)
+		go s.flagLimiter.runCleanup(rateLimitCleanupInterval)
+	}
+	if cfg.VoteRateLimit > 0 {
+		s.voteLimiter = newRateLimiter(cfg.VoteRateLimit)
+		go s.voteLimiter.runCleanup(rateLimitCleanupInterval)
+	}
+
+	return s, nil
+}
+
+// routes registers the server's handlers on mux. The API is versioned by
+// path prefix:
+//
+//	/v1/reviews, /v1/ws, ...  version 1, registered by routesV1
+//	/reviews, /ws, ...        aliases of v1, for clients from before versioning
+//
+// A new version gets its own mux, registered under its prefix next to v1,
+// and an entry in apiVersions. The unversioned paths keep mapping to v1 so
+// existing clients don't break when it ships. The health checks, metrics and
+// specification describe the server rather than the API, so they aren't
+// versioned.
+func (s *Server) routes(mux *http.ServeMux) {
+	v1 := http.NewServeMux()
+	s.routesV1(v1)
+	mux.Handle("/v1/", withAPIVersion("v1", v1))
+	mux.Handle("/", v1)
+
+	// Health checks for liveness and readiness probes
+	mux.HandleFunc("/healthz", allowMethods(livenessHandler, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/readyz", allowMethods(s.readinessHandler, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/health", allowMethods(s.readinessHandler, http.MethodGet, http.MethodHead))
+
+	// Metrics for Prometheus to scrape
+	mux.HandleFunc("/metrics", allowMethods(s.metricsHandler, http.MethodGet))
+
+	// Machine-readable description of the API
+	mux.HandleFunc("/openapi.json", s.withCORS(openAPIHandler, http.MethodGet))
+}
+
+// routesV1 registers the handlers of version 1 of the API on mux, each with
+// the methods it accepts; other methods get a 405 listing them. Writes to
+// the /reviews endpoints, /delete-review and /admin require credentials;
+// reads are public apart from /admin/reviews.
+func (s *Server) routesV1(mux *http.ServeMux) {
+	mux.HandleFunc("/reviews", s.withCORS(s.withAuth(withETag(s.withCache(s.reviewsHandler))), http.MethodGet, http.MethodHead, http.MethodPost))
+	// Handler for a single review addressed by ID, and the actions on it
+	mux.HandleFunc("/reviews/", s.withCORS(s.withAuth(s.reviewHandler), http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete))
+	mux.HandleFunc("/reviews/stats", s.withCORS(s.statsHandler, http.MethodGet))                       // Handler for the review count and average rating
+	mux.HandleFunc("/reviews/count", s.withCORS(s.withCache(s.countHandler), http.MethodGet))          // Handler for the review count alone
+	mux.HandleFunc("/reviews/histogram", s.withCORS(s.histogramHandler, http.MethodGet))               // Handler for review counts per star rating
+	mux.HandleFunc("/reviews/latest", s.withCORS(s.latestHandler, http.MethodGet))                     // Handler for the few most recent reviews
+	mux.HandleFunc("/reviews/summary", s.withCORS(s.summaryHandler, http.MethodGet))                   // Handler for an overview with the top keywords
+	mux.HandleFunc("/reviews/feed.xml", s.withCORS(s.feedHandler, http.MethodGet))                     // Handler for the latest reviews as an Atom feed
+	mux.HandleFunc("/reviews/bulk", s.withCORS(s.withAuth(s.bulkHandler), http.MethodPost))            // Handler for importing many reviews at once
+	mux.HandleFunc("/reviews/export", s.withCORS(s.exportHandler, http.MethodGet))                     // Handler for downloading reviews as CSV
+	mux.HandleFunc("/reviews/import", s.withCORS(s.withAuth(s.importHandler), http.MethodPost))        // Handler for uploading reviews as CSV
+	mux.HandleFunc("/reviews/search", s.withCORS(s.searchHandler, http.MethodGet))                     // Handler for ranked full-text search
+	mux.HandleFunc("/reviews/stream", s.withCORS(s.streamHandler, http.MethodGet))                     // Handler for live updates as Server-Sent Events
+	mux.HandleFunc("/ws", allowMethods(s.websocketHandler, http.MethodGet))                            // Handler for live updates and moderation over a WebSocket
+	mux.HandleFunc("/delete-review", s.withCORS(s.withAuth(s.deleteReviewHandler), http.MethodDelete)) // Handler for deleting a review
+
+	// Maintenance endpoints for admins
+	mux.HandleFunc("/admin/reload", allowMethods(s.requireAdmin(s.reloadHandler), http.MethodPost))
+	mux.HandleFunc("/admin/reviews", allowMethods(s.requireAdmin(s.adminReviewsHandler), http.MethodGet, http.MethodDelete)) // Handler for listing reviews with private fields and purging them
+	mux.HandleFunc("/admin/dbcheck", allowMethods(s.requireAdmin(s.dbCheckHandler), http.MethodGet))                         // Handler for checking the reviews file for corruption
+	mux.HandleFunc("/admin/backup", allowMethods(s.requireAdmin(s.backupHandler), http.MethodGet))                           // Handler for downloading a snapshot of the reviews file
+}
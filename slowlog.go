@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// slowLogStore wraps a reviewStore to log every operation on the storage
+// that takes longer than threshold, with how long it took and how many
+// reviews or bytes it handled, to show when the reviews have outgrown their
+// storage. Faster operations only cost a clock read. location is passed
+// through, since it doesn't touch the storage.
+type slowLogStore struct {
+	reviewStore
+	threshold time.Duration
+}
+
+// load loads the reviews from the wrapped store, logging it if it is slow
+func (s slowLogStore) load(ctx context.Context) ([]Review, error) {
+	start := time.Now()
+	list, err := s.reviewStore.load(ctx)
+	s.observe(ctx, "load", start, err, "reviews", len(list))
+	return list, err
+}
+
+// save saves list to the wrapped store, logging it if it is slow
+func (s slowLogStore) save(ctx context.Context, list []Review, nextID int) error {
+	start := time.Now()
+	err := s.reviewStore.save(ctx, list, nextID)
+	s.observe(ctx, "save", start, err, "reviews", len(list))
+	return err
+}
+
+// ping checks the wrapped store, logging it if it is slow
+func (s slowLogStore) ping() error {
+	start := time.Now()
+	err := s.reviewStore.ping()
+	s.observe(context.Background(), "ping", start, err)
+	return err
+}
+
+// checkWritable checks the wrapped store, logging it if it is slow
+func (s slowLogStore) checkWritable() error {
+	start := time.Now()
+	err := s.reviewStore.checkWritable()
+	s.observe(context.Background(), "check_writable", start, err)
+	return err
+}
+
+// loadDocument loads the reviews file from the wrapped store for a
+// migration, backup or check, logging it if it is slow
+func (s slowLogStore) loadDocument(ctx context.Context) ([]byte, error) {
+	start := time.Now()
+	data, err := s.reviewStore.loadDocument(ctx)
+	s.observe(ctx, "load_document", start, err, "bytes", len(data))
+	return data, err
+}
+
+// saveDocument saves a reviews file to the wrapped store, logging it if it
+// is slow
+func (s slowLogStore) saveDocument(ctx context.Context, data []byte) error {
+	start := time.Now()
+	err := s.reviewStore.saveDocument(ctx, data)
+	s.observe(ctx, "save_document", start, err, "bytes", len(data))
+	return err
+}
+
+// observe logs the operation op that started at start if it took longer
+// than the threshold, along with the attributes in size
+func (s slowLogStore) observe(ctx context.Context, op string, start time.Time, err error, size ...interface{}) {
+	elapsed := time.Since(start)
+	if elapsed < s.threshold {
+		return
+	}
+	attrs := append([]interface{}{"request_id", requestID(ctx), "op", op}, size...)
+	attrs = append(attrs, "duration_ms", float64(elapsed.Microseconds())/1000, "threshold_ms", s.threshold.Milliseconds())
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Warn("Slow storage operation", attrs...)
+}
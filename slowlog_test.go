@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSlowLogStoreLogsEveryOperation(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	store := slowLogStore{reviewStore: fileStore{path: filepath.Join(t.TempDir(), "reviews.json")}, threshold: 0}
+	ctx := t.Context()
+	if err := store.ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.checkWritable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.save(ctx, []Review{{ID: 1}}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.loadDocument(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.saveDocument(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []string{"ping", "check_writable", "save", "load", "load_document", "save_document"} {
+		if !strings.Contains(logs.String(), "op="+op+" ") {
+			t.Errorf("no slow log for %s in:\n%s", op, logs.String())
+		}
+	}
+}
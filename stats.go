@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// reviewStats is the body of a /reviews/stats response
+type reviewStats struct {
+	Count   int     `json:"count"`
+	Average float64 `json:"average"` // Mean rating rounded to two decimals; 0 when there are no reviews
+}
+
+// statsHandler handles fetching the number of reviews and their average
+// rating, optionally narrowed down with the filter parameters such as
+// product_id. The stats of a product with no other filters are served from
+// the per-product cache.
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+	cacheable := filter.productID != "" && filter == reviewFilter{productID: filter.productID, status: statusApproved, unescape: filter.unescape}
+	if cacheable {
+		if stats, ok := s.productStats.get(filter.productID); ok {
+			writeJSON(w, http.StatusOK, stats)
+			return
+		}
+	}
+
+	// Cache entries are computed under the read lock; see productStatsCache
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list, err := s.readReviews(r.Context())
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	stats := computeStats(filter.apply(list))
+	if cacheable {
+		s.productStats.put(filter.productID, stats)
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// reviewCount is the body of a /reviews/count response
+type reviewCount struct {
+	Count int `json:"count"`
+}
+
+// countHandler handles fetching the number of reviews, optionally narrowed
+// down with the filter parameters such as product_id, for badges that don't
+// need the reviews themselves. Counts are served from the response cache
+// until the reviews change.
+func (s *Server) countHandler(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	count := 0
+	for _, review := range list {
+		if filter.match(review) {
+			count++
+		}
+	}
+	writeJSON(w, http.StatusOK, reviewCount{Count: count})
+}
+
+// computeStats counts the reviews in list and averages their ratings
+func computeStats(list []Review) reviewStats {
+	stats := reviewStats{Count: len(list)}
+	if stats.Count == 0 {
+		return stats
+	}
+
+	total := 0
+	for _, review := range list {
+		total += review.Rating
+	}
+	stats.Average = math.Round(float64(total)/float64(stats.Count)*100) / 100
+	return stats
+}
+
+// histogramHandler handles fetching the number of reviews with each star
+// rating, optionally narrowed down with the filter parameters such as
+// product_id. All five ratings are always present, with zero counts where
+// there are no reviews.
+func (s *Server) histogramHandler(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ratingHistogram(filter.apply(list)))
+}
+
+// ratingHistogram counts the reviews in list by rating, keyed "1" to "5".
+// Reviews saved without a rating aren't counted.
+func ratingHistogram(list []Review) map[string]int {
+	counts := map[string]int{}
+	for rating := 1; rating <= 5; rating++ {
+		counts[strconv.Itoa(rating)] = 0
+	}
+	for _, review := range list {
+		if review.Rating >= 1 && review.Rating <= 5 {
+			counts[strconv.Itoa(review.Rating)]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// reviewsDocument is the layout of the reviews file
+type reviewsDocument struct {
+	SchemaVersion int      `json:"schema_version"`
+	NextID        int      `json:"next_id"` // ID the next review gets; above every ID handed out, purged reviews included
+	Reviews       []Review `json:"reviews"`
+}
+
+// Storage drivers selectable with DB_DRIVER
+const (
+	driverFile = "file" // A JSON file at DB_PATH
+)
+
+// reviewStore is where the reviews are persisted, which is the single
+// source of truth for them. The server holds its mutex around every call,
+// for writing when the reviews change, so saves never overlap with anything
+// but loads may run concurrently. Migrations, /admin/dbcheck and
+// /admin/backup work on the stored reviews as a document in the reviews
+// file format, which every store must be able to load and save as a whole.
+// Filters and sorting run in memory over everything load returns. The only
+// driver keeps the reviews in a JSON file, which has no indexes, so none of
+// the filtered columns are indexed. A database driver that pushes filters
+// down should index product_id, created_at and (status, created_at), which
+// listings filter and sort by.
+type reviewStore interface {
+	// load returns every stored review, or an empty slice if there are none
+	load(ctx context.Context) ([]Review, error)
+
+	// save replaces the stored reviews with list and records nextID as the
+	// ID the next review gets, so that either all of the change is saved or
+	// none of it
+	save(ctx context.Context, list []Review, nextID int) error
+
+	// ping checks that the storage is reachable, cheaply enough for probes
+	ping() error
+
+	// checkWritable checks that save can succeed, without changing the
+	// stored reviews. It is only called at startup.
+	checkWritable() error
+
+	// loadDocument returns the stored reviews encoded as a reviews file,
+	// whatever its schema version, or nil if nothing has been stored yet
+	loadDocument(ctx context.Context) ([]byte, error)
+
+	// saveDocument replaces the stored reviews with the reviews file in
+	// data, like save
+	saveDocument(ctx context.Context, data []byte) error
+
+	// location describes where the reviews are stored, for messages
+	location() string
+}
+
+// newReviewStore returns the store for the driver selected in cfg, logging
+// operations slower than the configured threshold
+func newReviewStore(cfg Config) (reviewStore, error) {
+	switch cfg.DBDriver {
+	case driverFile:
+		return slowLogStore{reviewStore: fileStore{path: cfg.ReviewsFile}, threshold: cfg.SlowQuery}, nil
+	}
+	return nil, fmt.Errorf("unsupported DB_DRIVER %q", cfg.DBDriver)
+}
+
+// fileStore keeps the reviews in a JSON file, rewritten on every change
+type fileStore struct {
+	path string
+}
+
+// load reads all reviews from the file. A missing file holds no reviews.
+func (f fileStore) load(ctx context.Context) ([]Review, error) {
+	file, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// File doesn't exist, no reviews to load
+			return []Review{}, nil
+		}
+		return nil, err
+	}
+
+	// Parse JSON data into a fresh reviews slice, so every read starts from
+	// exactly what is in the file
+	var doc reviewsDocument
+	if err := json.Unmarshal(file, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", f.path, err)
+	}
+	if doc.Reviews == nil {
+		// "reviews": null or a missing key holds no reviews
+		doc.Reviews = []Review{}
+	}
+	if doc.SchemaVersion != schemaVersion {
+		return nil, fmt.Errorf("%s has schema version %d, want %d; restart the server to migrate it", f.path, doc.SchemaVersion, schemaVersion)
+	}
+	return doc.Reviews, nil
+}
+
+// save writes list to the file, replacing its contents atomically
+func (f fileStore) save(ctx context.Context, list []Review, nextID int) error {
+	data, err := json.MarshalIndent(reviewsDocument{SchemaVersion: schemaVersion, NextID: nextID, Reviews: list}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+// ping checks that the directory holding the file is reachable and that the
+// path doesn't name a directory itself. A missing file is fine, since it is
+// created on the first write, but a missing directory means the storage
+// isn't there. The directory isn't created, since it may be a mount that
+// isn't ready yet.
+func (f fileStore) ping() error {
+	dir := filepath.Dir(f.path)
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("directory %s of DB_PATH does not exist; create it or set DB_PATH to a file in an existing directory", dir)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s in DB_PATH is not a directory", dir)
+	}
+
+	info, err = os.Stat(f.path)
+	if err == nil && info.IsDir() {
+		return fmt.Errorf("DB_PATH %s is a directory; it must name the reviews file, such as %s", f.path, filepath.Join(f.path, defaultReviewsFile))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadDocument returns the contents of the file, or nil if it doesn't exist
+func (f fileStore) loadDocument(ctx context.Context) ([]byte, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// saveDocument replaces the file with data atomically
+func (f fileStore) saveDocument(ctx context.Context, data []byte) error {
+	return writeFileAtomic(f.path, data)
+}
+
+// location returns the path of the file
+func (f fileStore) location() string {
+	return f.path
+}
+
+// checkWritable checks that a temporary file can be created next to the
+// file, as writeFileAtomic does on every save
+func (f fileStore) checkWritable() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("directory of DB_PATH %s is not writable: %w", f.path, err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// readReviews reads all reviews from the store. It gives up without
+// touching the store once ctx is done. The caller must hold the mutex.
+func (s *Server) readReviews(ctx context.Context) ([]Review, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.store.load(ctx)
+}
+
+// writeReviews saves the given reviews to the store, replacing its
+// contents, along with the ID counter. It gives up without touching the
+// store once ctx is done. The caller must hold the mutex for writing.
+func (s *Server) writeReviews(ctx context.Context, list []Review) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.countIDs(list)
+	if err := s.store.save(ctx, list, s.idCounter+1); err != nil {
+		return err
+	}
+
+	s.metrics.setReviews(countUndeleted(list))
+	if s.cache != nil {
+		s.cache.purge()
+	}
+	return nil
+}
+
+// errDuplicateReview is returned by addReview when the same name and text
+// were submitted within the dedupe window
+var errDuplicateReview = errors.New("duplicate review")
+
+// addReviews assigns IDs to the given reviews and appends them to the file
+// in a single write, so either all of them are saved or none are. Reviews
+// without a submission time get the current time, and all of them await
+// moderation and have their sentiment and language detected. It returns the
+// reviews as saved.
+func (s *Server) addReviews(ctx context.Context, added []Review) ([]Review, error) {
+	return s.insertReviews(ctx, added, nil)
+}
+
+// addReview saves a single new review like addReviews, unless a review with
+// the same name and text was submitted within the configured dedupe window,
+// in which case it returns errDuplicateReview. A reply is checked with
+// checkParent.
+func (s *Server) addReview(ctx context.Context, review Review) (Review, error) {
+	added, err := s.insertReviews(ctx, []Review{review}, func(list, added []Review) error {
+		return s.checkNewReview(list, &added[0])
+	})
+	if err != nil {
+		return Review{}, err
+	}
+	return added[0], nil
+}
+
+// checkNewReview checks a new review against the stored reviews in list
+// for addReview: it must not duplicate a recent review, and if it is a reply
+// its parent must pass checkParent
+func (s *Server) checkNewReview(list []Review, review *Review) error {
+	since := time.Now().Add(-s.config.DedupeWindow)
+	for _, existing := range list {
+		if existing.Name == review.Name && existing.Review == review.Review && existing.CreatedAt.After(since) && existing.DeletedAt == nil {
+			return errDuplicateReview
+		}
+	}
+	if review.ParentID != nil {
+		return checkParent(list, review)
+	}
+	return nil
+}
+
+// insertReviews implements addReviews. If check isn't nil it is called with
+// the stored reviews and the ones being added while the mutex is held; it
+// may adjust the added reviews, and an error from it aborts the insert.
+func (s *Server) insertReviews(ctx context.Context, added []Review, check func(list, added []Review) error) ([]Review, error) {
+	// Lock the mutex before modifying the reviews
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list, err := s.readReviews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(list, added); err != nil {
+			return nil, err
+		}
+	}
+
+	// Assign unique IDs to the new reviews, above any ID already in the file
+	s.countIDs(list)
+	now := time.Now().UTC()
+	for i := range added {
+		s.idCounter++
+		added[i].ID = s.idCounter
+		added[i].Status = statusPending
+		added[i].Sentiment = s.sentiment.classify(s.plainText(added[i].Review))
+		added[i].Lang = defaultDetector.detect(s.plainText(added[i].Review))
+		if added[i].CreatedAt.IsZero() {
+			added[i].CreatedAt = now
+		}
+	}
+
+	// Save reviews to the file
+	if err := s.writeReviews(ctx, append(list, added...)); err != nil {
+		return nil, err
+	}
+	for _, review := range added {
+		s.productStats.invalidate(review.ProductID)
+	}
+	return added, nil
+}
+
+// countIDs raises the ID counter to the highest ID in list, in case reviews
+// were added to the file out-of-band. The caller must hold the mutex for
+// writing.
+func (s *Server) countIDs(list []Review) {
+	for _, review := range list {
+		if review.ID > s.idCounter {
+			s.idCounter = review.ID
+		}
+	}
+}
+
+// writeFileAtomic replaces the file at path with data. It writes to a
+// temporary file in the same directory and renames it into place, so
+// readers and crashes never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// findReview returns a pointer to the review in list with the given ID, or
+// nil if there is none or it has been deleted
+func findReview(list []Review, id int) *Review {
+	review := findStoredReview(list, id)
+	if review == nil || review.DeletedAt != nil {
+		return nil
+	}
+	return review
+}
+
+// findStoredReview returns a pointer to the review in list with the given
+// ID, deleted or not, or nil if there is none
+func findStoredReview(list []Review, id int) *Review {
+	for i := range list {
+		if list[i].ID == id {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// countUndeleted returns the number of reviews in list that haven't been
+// deleted, as reported by the reviews_total gauge
+func countUndeleted(list []Review) int {
+	n := 0
+	for _, review := range list {
+		if review.DeletedAt == nil {
+			n++
+		}
+	}
+	return n
+}
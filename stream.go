@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How often an idle event stream sends a comment, so proxies don't close it
+const streamKeepAlive = 30 * time.Second
+
+// Events buffered per subscriber; a subscriber that falls further behind
+// misses events rather than holding up the publisher
+const streamBuffer = 16
+
+// broadcaster is an in-process pub/sub that fans reviews out to every
+// subscribed event stream
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan Review]struct{}
+	closed      bool // Set by close; subscribe then returns a closed channel
+}
+
+// newBroadcaster returns a broadcaster with no subscribers
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subscribers: map[chan Review]struct{}{}}
+}
+
+// subscribe returns a channel receiving every review published from now on.
+// It is closed by unsubscribe or close.
+func (b *broadcaster) subscribe() chan Review {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Review, streamBuffer)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops sending reviews to ch and closes it
+func (b *broadcaster) unsubscribe(ch chan Review) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish sends review to every subscriber without blocking
+func (b *broadcaster) publish(review Review) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- review:
+		default:
+			// Subscriber is too far behind; drop the event for it
+		}
+	}
+}
+
+// close closes every subscriber's channel, ending their streams, and makes
+// later subscriptions end immediately. It is called on shutdown.
+func (b *broadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// streamHandler handles /reviews/stream, a Server-Sent Events stream that
+// sends each review as it is posted or approved in a data: event. Only
+// approved reviews are sent unless the client is an admin. The stream
+// stays open until the client disconnects or the server shuts down.
+func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming is not supported")
+		return
+	}
+
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// Client went away
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case review, ok := <-events:
+			if !ok {
+				// Server is shutting down
+				return
+			}
+			if review.Status != statusApproved && !s.isAdmin(r) {
+				continue
+			}
+			data, err := json.Marshal(s.visibleReview(r, review))
+			if err != nil {
+				requestLogger(r).Error("Failed to encode stream event", "review_id", review.ID, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", review.ID, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Number of keywords in a /reviews/summary response
+const summaryKeywords = 10
+
+// Words never reported as keywords
+//
+//go:embed stopwords.txt
+var stopwordsText string
+
+// stopwords is stopwordsText parsed
+var stopwords = parseStopwords(stopwordsText)
+
+// reviewSummary is the body of a /reviews/summary response
+type reviewSummary struct {
+	Count    int            `json:"count"`
+	Average  float64        `json:"average"`  // Mean rating rounded to two decimals; 0 when there are no reviews
+	Latest   *Review        `json:"latest"`   // Most recently submitted review; null when there are none
+	Keywords []keywordCount `json:"keywords"` // Most frequent words in the text, most frequent first
+}
+
+// keywordCount is a word and how many times it occurs
+type keywordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// summaryHandler handles fetching an overview of reviews for dashboards:
+// their count and average rating, the latest one and the words they use
+// most. The reviews can be narrowed down with the filter parameters such as
+// product_id.
+func (s *Server) summaryHandler(w http.ResponseWriter, r *http.Request) {
+	filter, apiErr := s.parseReviewFilter(r)
+	if apiErr != nil {
+		writeJSON(w, apiErr.Status, apiErr)
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	s.mutex.RUnlock()
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+
+	list = filter.apply(list)
+	stats := computeStats(list)
+	summary := reviewSummary{Count: stats.Count, Average: stats.Average, Keywords: s.topKeywords(list, summaryKeywords)}
+	if latest := newestReviews(list, 1); len(latest) > 0 {
+		review := s.visibleReview(r, latest[0])
+		summary.Latest = &review
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// topKeywords returns the n words that occur most often in the text of the
+// reviews in list, leaving out stopwords, numbers and words shorter than
+// three letters. Words that occur equally often are in alphabetical order.
+func (s *Server) topKeywords(list []Review, n int) []keywordCount {
+	counts := map[string]int{}
+	for _, review := range list {
+		// The text may be stored HTML-escaped, and entities aren't words
+		for _, word := range tokenize(s.plainText(review.Review)) {
+			if utf8.RuneCountInString(word) < 3 || stopwords[word] || !strings.ContainsFunc(word, unicode.IsLetter) {
+				continue
+			}
+			counts[word]++
+		}
+	}
+
+	keywords := make([]keywordCount, 0, len(counts))
+	for word, count := range counts {
+		keywords = append(keywords, keywordCount{Word: word, Count: count})
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if keywords[i].Count != keywords[j].Count {
+			return keywords[i].Count > keywords[j].Count
+		}
+		return keywords[i].Word < keywords[j].Word
+	})
+	if len(keywords) > n {
+		keywords = keywords[:n]
+	}
+	return keywords
+}
+
+// parseStopwords parses a stopword list in the format of stopwords.txt
+func parseStopwords(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			words[strings.ToLower(word)] = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the tags of a review
+const (
+	maxTags      = 10
+	maxTagLength = 30
+)
+
+// normalizeTags lowercases and trims the tags of a review being submitted,
+// dropping empty and repeated ones, and checks that they are within the
+// limits. Tags may only hold letters, digits, spaces, hyphens and
+// underscores, so they need no escaping wherever they are displayed. It
+// returns the first problem found, or "" if the tags are valid.
+func normalizeTags(review *Review) string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range review.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return fmt.Sprintf("%q must be at most %d characters", tag, maxTagLength)
+		}
+		for _, c := range tag {
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != ' ' && c != '-' && c != '_' {
+				return fmt.Sprintf("%q may only contain letters, digits, spaces, hyphens and underscores", tag)
+			}
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return fmt.Sprintf("must be at most %d tags", maxTags)
+	}
+	review.Tags = tags
+	return ""
+}
+
+// hasTag reports whether review is tagged with tag
+func (review Review) hasTag(tag string) bool {
+	for _, t := range review.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
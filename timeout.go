@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+)
+
+// timeoutWriter holds back the response of a handler running under
+// withTimeout, so that a 503 can be sent in its place if the handler is
+// still running at the deadline. Flushing commits the response: what was
+// held back is sent, later writes go straight to the client, and the
+// deadline can then only cancel the request's context.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu        sync.Mutex
+	status    int
+	body      bytes.Buffer
+	committed bool
+	timedOut  bool
+}
+
+// newTimeoutWriter returns a timeoutWriter sending to w. The handler's
+// header map starts as a copy of w's, so headers set further out, such as
+// the request ID, can still be read through it.
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{w: w, header: w.Header().Clone()}
+}
+
+// Header returns the handler's own header map, copied to the client's when
+// the response is committed, so a handler that runs past the deadline can't
+// race with the 503
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// WriteHeader records the status code to send later
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.committed || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// Write buffers the body until the response is committed. Once the request
+// has timed out it fails with http.ErrHandlerTimeout.
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.committed {
+		return tw.w.Write(p)
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(p)
+}
+
+// Flush commits the response and sends it to the client, so streaming
+// handlers work through the writer
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.commit()
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the response once the handler has returned
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.commit()
+}
+
+// timeout marks the request as timed out if nothing has been sent yet, and
+// reports whether it did. The caller then sends the 503 itself.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.committed {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
+
+// commit sends the headers and body held back so far. The caller must hold
+// the mutex.
+func (tw *timeoutWriter) commit() {
+	if tw.committed {
+		return
+	}
+	tw.committed = true
+
+	for key, values := range tw.header {
+		tw.w.Header()[key] = values
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	tw.w.WriteHeader(tw.status)
+	tw.w.Write(tw.body.Bytes())
+	tw.body.Reset()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Header that asks for a submission to be validated without saving it, like
+// the validate query parameter
+const validateOnlyHeader = "X-Validate-Only"
+
+// fieldErrors collects the problems with a submitted review, keyed by the
+// JSON name of the field each is about, so that every invalid field can be
+// reported in one response
+type fieldErrors map[string]string
+
+// add records msg as the problem with field, unless one was found already
+func (e fieldErrors) add(field, msg string) {
+	if _, ok := e[field]; !ok {
+		e[field] = msg
+	}
+}
+
+// message returns the problems as a single line, such as "name: required;
+// rating: must be between 1 and 5", for responses that only have room for
+// one message per review. It is empty if there are no problems.
+func (e fieldErrors) message() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field + ": " + e[field]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// apiError returns the 400 response listing the problems
+func (e fieldErrors) apiError() *apiError {
+	apiErr := newAPIError(http.StatusBadRequest, "validation_failed", "Invalid review: "+e.message())
+	apiErr.Errors = e
+	return apiErr
+}
+
+// validateOnlyRequest reports whether r asks for its review to be validated
+// without saving it, with ?validate=true or the X-Validate-Only header
+func validateOnlyRequest(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("validate")
+	if value == "" {
+		value = r.Header.Get(validateOnlyHeader)
+	}
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// validateSubmission handles a dry run of POST /reviews, so forms can give
+// live feedback without creating reviews. The review goes through every
+// check a submission does, including the duplicate and parent checks
+// against the stored reviews, and the response is {"valid":true} or the
+// error the submission would get. Nothing is saved, and dry runs don't count
+// towards the submission rate limit.
+func (s *Server) validateSubmission(w http.ResponseWriter, r *http.Request) {
+	review, ok := s.decodeNewReview(w, r)
+	if !ok {
+		return
+	}
+
+	s.mutex.RLock()
+	list, err := s.readReviews(r.Context())
+	if err == nil {
+		err = s.checkNewReview(list, &review)
+	}
+	s.mutex.RUnlock()
+	if err != nil {
+		s.writeSubmitError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"valid": true})
+}
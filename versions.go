@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Versions of the API, each served under a path prefix such as /v1. The
+// last one is the current version, which unversioned paths are aliases of.
+var apiVersions = []string{"v1"}
+
+// apiVersionKey is the context key under which withAPIVersion stores the
+// version prefix of a request
+type apiVersionKey struct{}
+
+// withAPIVersion is a middleware function that strips the /version prefix
+// from the path, so the handlers of every version see paths such as
+// /reviews, and records the prefix for apiPath
+func withAPIVersion(version string, next http.Handler) http.Handler {
+	prefix := "/" + version
+	stripped := http.StripPrefix(prefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stripped.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, prefix)))
+	})
+}
+
+// apiPath returns path, such as /reviews/1, as the client making r should
+// address it: under the version prefix it used, if any
+func apiPath(r *http.Request, path string) string {
+	prefix, _ := r.Context().Value(apiVersionKey{}).(string)
+	return prefix + path
+}
+
+// unversionedPath returns path without any version prefix, for middleware
+// that runs before the prefix is stripped
+func unversionedPath(path string) string {
+	for _, version := range apiVersions {
+		if strings.HasPrefix(path, "/"+version+"/") {
+			return path[len(version)+1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,45 @@
+package main
+
+import "net/http"
+
+// voteCounts is the body of a vote response
+type voteCounts struct {
+	ID        int `json:"id"`
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+}
+
+// handleVote handles /reviews/{id}/upvote and /reviews/{id}/downvote, which
+// add one to the review's vote counter and return the new counts. Any reader
+// may vote, and votes are rate limited per client. The increment happens
+// under the write lock, so concurrent votes are never lost. Only approved
+// reviews can be voted on.
+func (s *Server) handleVote(w http.ResponseWriter, r *http.Request, id int, up bool) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.limitClient(w, r, s.voteLimiter, "Too many votes. Please try again later.") {
+		return
+	}
+
+	review, ok := s.modifyReview(w, r, id, func(review *Review) *apiError {
+		if review.Status != statusApproved {
+			return newAPIError(http.StatusNotFound, "not_found", "Review not found")
+		}
+		if up {
+			review.Upvotes++
+		} else {
+			review.Downvotes++
+		}
+		return nil
+	})
+	if ok {
+		writeJSON(w, http.StatusOK, voteCounts{ID: review.ID, Upvotes: review.Upvotes, Downvotes: review.Downvotes})
+	}
+}
+
+// helpfulness ranks a review by its upvotes minus its downvotes
+func (review Review) helpfulness() int {
+	return review.Upvotes - review.Downvotes
+}
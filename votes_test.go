@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestReadersCanVote(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.post(t, "Ann", "Fine")
+
+	for _, action := range []string{"upvote", "upvote", "downvote"} {
+		w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/%s", review.ID, action), nil, anonymous())
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s without credentials: got %d %s", action, w.Code, w.Body)
+		}
+	}
+	got := ts.storedReview(t, review.ID)
+	if got.Upvotes != 2 || got.Downvotes != 1 {
+		t.Errorf("got %d upvotes and %d downvotes, want 2 and 1", got.Upvotes, got.Downvotes)
+	}
+}
+
+func TestVotesOnlyOnApprovedReviews(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.submit(t, "Ann", "Fine")
+
+	if w := ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/upvote", review.ID), nil, anonymous()); w.Code != http.StatusNotFound {
+		t.Errorf("upvote a pending review: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestVotesAreRateLimited(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"VOTE_RATE_LIMIT_PER_MINUTE": "2"})
+	review := ts.post(t, "Ann", "Fine")
+	path := fmt.Sprintf("/reviews/%d/upvote", review.ID)
+
+	for i := 0; i < 2; i++ {
+		if w := ts.do(t, http.MethodPost, path, nil, anonymous()); w.Code != http.StatusOK {
+			t.Fatalf("vote %d: got %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	w := ts.do(t, http.MethodPost, path, nil, anonymous())
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("vote past the limit: got %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestHelpfulSortUsesReaderVotes(t *testing.T) {
+	ts := newTestServer(t, nil)
+	first := ts.post(t, "Ann", "Fine")
+	second := ts.post(t, "Bob", "Good")
+	ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/upvote", second.ID), nil, anonymous())
+	ts.do(t, http.MethodPost, fmt.Sprintf("/reviews/%d/downvote", first.ID), nil, anonymous())
+
+	w := ts.do(t, http.MethodGet, "/reviews?sort=helpful", nil, anonymous())
+	var list []Review
+	decodeBody(t, w, &list)
+	if len(list) != 2 || list[0].ID != second.ID {
+		t.Errorf("got %+v, want review %d first", list, second.ID)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// How many times a failed webhook delivery is retried, and the wait before
+// the first retry, which doubles after each one
+const (
+	webhookRetries    = 2
+	webhookRetryDelay = time.Second
+)
+
+// webhookNotifier POSTs new reviews to the configured webhook URLs
+type webhookNotifier struct {
+	urls   []string
+	client *http.Client
+	delay  time.Duration // Wait before the first retry
+}
+
+// newWebhookNotifier returns a notifier for urls whose deliveries each give
+// up after timeout
+func newWebhookNotifier(urls []string, timeout time.Duration) *webhookNotifier {
+	return &webhookNotifier{urls: urls, client: &http.Client{Timeout: timeout}, delay: webhookRetryDelay}
+}
+
+// notify sends review to every webhook in the background, so a slow or
+// failing webhook never holds up the request that created the review.
+// requestID ties the deliveries to that request in the logs and is sent in
+// the X-Request-ID header.
+func (n *webhookNotifier) notify(review Review, requestID string) {
+	if len(n.urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		slog.Error("Failed to encode webhook payload", "request_id", requestID, "review_id", review.ID, "error", err)
+		return
+	}
+	for _, url := range n.urls {
+		go n.deliver(url, body, review.ID, requestID)
+	}
+}
+
+// deliver POSTs body to url, retrying failures, and logs the outcome
+func (n *webhookNotifier) deliver(url string, body []byte, reviewID int, requestID string) {
+	delay := n.delay
+	for attempt := 1; ; attempt++ {
+		err := n.post(url, body, requestID)
+		if err == nil {
+			slog.Debug("Delivered webhook", "request_id", requestID, "review_id", reviewID, "url", url, "attempt", attempt)
+			return
+		}
+		if attempt > webhookRetries {
+			slog.Error("Webhook delivery failed", "request_id", requestID, "review_id", reviewID, "url", url, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("Webhook delivery attempt failed", "request_id", requestID, "review_id", reviewID, "url", url, "attempt", attempt, "error", err, "retry_in", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt. Any status other than 2xx is a failure.
+func (n *webhookNotifier) post(url string, body []byte, requestID string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, requestID)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // Drain the body so the connection can be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GUID that RFC 6455 appends to the client's key to prove the server
+// understood the handshake
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket connection limits
+const (
+	wsPingInterval = 30 * time.Second // How often the server pings an idle client
+	wsPongWait     = 2 * wsPingInterval
+	wsWriteTimeout = 10 * time.Second
+	wsMaxMessage   = 64 << 10 // Largest message accepted from a client
+)
+
+// WebSocket frame opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// WebSocket close status codes
+const (
+	wsCloseNormal      = 1000
+	wsCloseGoingAway   = 1001
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+// Errors from reading a frame, each closing the connection with its own code
+var (
+	errWSProtocol = errors.New("websocket protocol error")
+	errWSTooBig   = errors.New("websocket message too big")
+)
+
+// wsMessage is a message sent to WebSocket clients
+type wsMessage struct {
+	Type   string  `json:"type"` // "review" or "error"
+	Review *Review `json:"review,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// wsCommand is a moderation command sent by an admin over a WebSocket, such
+// as {"action": "approve", "id": 5}
+type wsCommand struct {
+	Action string `json:"action"`
+	ID     int    `json:"id"`
+}
+
+// wsConn is an open WebSocket connection. Writes are serialized by mu, since
+// the read loop answers pings while the write loop pushes reviews.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+
+	// Opcode and payload so far of a fragmented message being read; message
+	// is nil between messages
+	opcode  byte
+	message []byte
+}
+
+// websocketHandler handles /ws, a WebSocket that pushes each review as it is
+// posted or approved, like /reviews/stream. Admins also see pending reviews
+// and may send moderation commands back; as with requireAdmin, nobody is an
+// admin when no credentials are configured. Connections are kept alive with
+// pings and end when the client closes them or the server shuts down.
+func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeJSONError(w, http.StatusBadRequest, "invalid_upgrade", "Expected a WebSocket upgrade request")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSONError(w, http.StatusUpgradeRequired, "invalid_upgrade", "Unsupported WebSocket version")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_upgrade", "Missing Sec-WebSocket-Key header")
+		return
+	}
+	// Browsers don't apply CORS to WebSockets, so check the origin here to
+	// stop other sites from connecting with a visitor's credentials
+	if origin := r.Header.Get("Origin"); origin != "" && s.allowedOrigin(origin) == "" {
+		writeJSONError(w, http.StatusForbidden, "forbidden_origin", "Origin not allowed")
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming_unsupported", "WebSockets are not supported")
+		return
+	}
+	// Subscribe before taking over the connection so no review is missed
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		requestLogger(r).Error("WebSocket hijack failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn, reader: rw.Reader}
+	admin := s.config.authEnabled() && s.isAdmin(r)
+
+	// Read commands in the background; done is closed when the client goes
+	// away or breaks the protocol
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.readWebsocket(r, ws, admin)
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			if err := ws.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case review, ok := <-events:
+			if !ok {
+				// Server is shutting down
+				ws.writeClose(wsCloseGoingAway, "server shutting down")
+				return
+			}
+			if review.Status != statusApproved && !admin {
+				continue
+			}
+			visible := s.visibleReview(r, review)
+			if err := ws.writeJSON(wsMessage{Type: "review", Review: &visible}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readWebsocket reads messages from ws, opened by r, until the connection
+// ends, answering pings and carrying out moderation commands from admins
+func (s *Server) readWebsocket(r *http.Request, ws *wsConn, admin bool) {
+	for {
+		ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		opcode, payload, err := ws.readMessage()
+		switch {
+		case errors.Is(err, errWSProtocol):
+			ws.writeClose(wsCloseProtocol, "protocol error")
+			return
+		case errors.Is(err, errWSTooBig):
+			ws.writeClose(wsCloseTooBig, "message too big")
+			return
+		case err != nil:
+			// Connection closed or timed out
+			return
+		}
+
+		switch opcode {
+		case wsOpClose:
+			ws.writeClose(wsCloseNormal, "")
+			return
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return
+			}
+		case wsOpPong:
+			// Read deadline is extended on every frame
+		case wsOpBinary:
+			ws.writeClose(wsCloseUnsupported, "binary messages are not supported")
+			return
+		case wsOpText:
+			if err := ws.writeJSON(s.runWebsocketCommand(r, payload, admin)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// runWebsocketCommand carries out a moderation command and returns the
+// reply for the client, with the updated review as visibleReview shows it.
+// The review is also pushed to every subscriber, this one included.
+func (s *Server) runWebsocketCommand(r *http.Request, payload []byte, admin bool) wsMessage {
+	if !s.config.authEnabled() {
+		return wsMessage{Type: "error", Error: "Admin access is disabled because no credentials are configured"}
+	}
+	if !admin {
+		return wsMessage{Type: "error", Error: s.unauthorizedError().Error}
+	}
+	if s.config.ReadOnly {
+		return wsMessage{Type: "error", Error: "The server is in read-only mode for maintenance"}
+	}
+	var cmd wsCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return wsMessage{Type: "error", Error: "Invalid command"}
+	}
+	status, ok := moderationActions[cmd.Action]
+	if !ok {
+		return wsMessage{Type: "error", Error: "Invalid action. Must be approve or reject."}
+	}
+
+	// The request context ended with the upgrade, so give the command its
+	// own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.RequestTimeout)
+	defer cancel()
+	review, err := s.setStatus(ctx, cmd.ID, status)
+	if errors.Is(err, errReviewNotFound) {
+		return wsMessage{Type: "error", Error: "Review not found"}
+	}
+	if err != nil {
+		requestLogger(r).Error("Reviews storage error", "error", err)
+		return wsMessage{Type: "error", Error: "Failed to access reviews"}
+	}
+	visible := s.visibleReview(r, review)
+	return wsMessage{Type: "review", Review: &visible}
+}
+
+// readMessage reads the next message, joining fragmented ones. Control
+// frames, which may arrive between fragments, are returned on their own, and
+// the fragments read so far are kept for the next call.
+func (ws *wsConn) readMessage() (byte, []byte, error) {
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if op >= wsOpClose {
+			return op, payload, nil
+		}
+
+		if op == wsOpContinuation {
+			if ws.message == nil {
+				return 0, nil, errWSProtocol
+			}
+		} else {
+			if ws.message != nil {
+				return 0, nil, errWSProtocol
+			}
+			ws.opcode = op
+			ws.message = []byte{}
+		}
+		if len(ws.message)+len(payload) > wsMaxMessage {
+			return 0, nil, errWSTooBig
+		}
+		ws.message = append(ws.message, payload...)
+		if fin {
+			message := ws.message
+			ws.message = nil
+			return ws.opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame from the client and unmasks its payload
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.reader, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// No extensions are negotiated, and clients must mask every frame
+		return false, 0, nil, errWSProtocol
+	}
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary, wsOpClose, wsOpPing, wsOpPong:
+	default:
+		return false, 0, nil, errWSProtocol
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsOpClose && (length > 125 || !fin) {
+		return false, 0, nil, errWSProtocol
+	}
+	if length > wsMaxMessage {
+		return false, 0, nil, errWSTooBig
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single unmasked frame
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// writeJSON sends v as a text message
+func (ws *wsConn) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode websocket message: %w", err)
+	}
+	return ws.writeFrame(wsOpText, data)
+}
+
+// writeClose sends a close frame with the given status code and reason
+func (ws *wsConn) writeClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return ws.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, ignoring case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// clientFrame builds a frame as a client sends it, masked with a fixed key
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// readerConn returns a wsConn reading the given frames
+func readerConn(frames ...[]byte) *wsConn {
+	return &wsConn{reader: bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil)))}
+}
+
+func TestReadFrameUnmasksPayload(t *testing.T) {
+	for _, size := range []int{0, 5, 125, 126, 200, 0xFFFF} {
+		payload := bytes.Repeat([]byte("abcdefg"), size/7+1)[:size]
+		fin, opcode, got, err := readerConn(clientFrame(true, wsOpText, payload)).readFrame()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !fin || opcode != wsOpText || !bytes.Equal(got, payload) {
+			t.Errorf("size %d: got fin=%v opcode=%d payload of %d bytes", size, fin, opcode, len(got))
+		}
+	}
+}
+
+func TestReadFrameRejectsInvalidFrames(t *testing.T) {
+	unmasked := []byte{0x80 | wsOpText, 2, 'h', 'i'}
+	reserved := clientFrame(true, wsOpText, []byte("hi"))
+	reserved[0] |= 0x40
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"unmasked", unmasked, errWSProtocol},
+		{"reserved bit", reserved, errWSProtocol},
+		{"unknown opcode", clientFrame(true, 0x3, nil), errWSProtocol},
+		{"fragmented control frame", clientFrame(false, wsOpPing, nil), errWSProtocol},
+		{"long control frame", clientFrame(true, wsOpPing, make([]byte, 126)), errWSProtocol},
+		{"too big", clientFrame(true, wsOpText, make([]byte, wsMaxMessage+1)), errWSTooBig},
+	}
+	for _, test := range tests {
+		if _, _, _, err := readerConn(test.frame).readFrame(); !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	frame := clientFrame(true, wsOpText, []byte("hello"))
+	_, _, _, err := readerConn(frame[:len(frame)-2]).readFrame()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReadMessageJoinsFragments(t *testing.T) {
+	ws := readerConn(
+		clientFrame(false, wsOpText, []byte("hel")),
+		clientFrame(true, wsOpPing, []byte("p")),
+		clientFrame(false, wsOpContinuation, []byte("l")),
+		clientFrame(true, wsOpContinuation, []byte("o")),
+	)
+
+	// The ping between the fragments comes out first, on its own
+	opcode, payload, err := ws.readMessage()
+	if err != nil || opcode != wsOpPing || string(payload) != "p" {
+		t.Fatalf("got opcode %d payload %q error %v, want the ping", opcode, payload, err)
+	}
+	opcode, payload, err = ws.readMessage()
+	if err != nil || opcode != wsOpText || string(payload) != "hello" {
+		t.Fatalf("got opcode %d payload %q error %v, want text \"hello\"", opcode, payload, err)
+	}
+}
+
+func TestReadMessageRejectsBadFragments(t *testing.T) {
+	half := make([]byte, wsMaxMessage/2+1)
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   error
+	}{
+		{"continuation first", [][]byte{clientFrame(true, wsOpContinuation, []byte("x"))}, errWSProtocol},
+		{"new message inside another", [][]byte{clientFrame(false, wsOpText, []byte("a")), clientFrame(true, wsOpText, []byte("b"))}, errWSProtocol},
+		{"fragments too big together", [][]byte{clientFrame(false, wsOpText, half), clientFrame(true, wsOpContinuation, half)}, errWSTooBig},
+	}
+	for _, test := range tests {
+		if _, _, err := readerConn(test.frames...).readMessage(); !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestWriteFrameLengths(t *testing.T) {
+	tests := []struct {
+		size int
+		head []byte
+	}{
+		{5, []byte{0x81, 5}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, test := range tests {
+		server, client := net.Pipe()
+		ws := &wsConn{conn: server}
+		payload := bytes.Repeat([]byte{'x'}, test.size)
+		go func() {
+			ws.writeFrame(wsOpText, payload)
+			server.Close()
+		}()
+
+		frame, err := io.ReadAll(client)
+		client.Close()
+		if err != nil {
+			t.Fatalf("size %d: %v", test.size, err)
+		}
+		if !bytes.HasPrefix(frame, test.head) || !bytes.Equal(frame[len(test.head):], payload) {
+			t.Errorf("size %d: got header % x, want % x", test.size, frame[:len(test.head)], test.head)
+		}
+	}
+}
+
+func TestWriteClose(t *testing.T) {
+	server, client := net.Pipe()
+	ws := &wsConn{conn: server}
+	go func() {
+		ws.writeClose(wsCloseGoingAway, "bye")
+		server.Close()
+	}()
+
+	frame, err := io.ReadAll(client)
+	client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x80 | wsOpClose, 5, 0x03, 0xE9, 'b', 'y', 'e'}
+	if !bytes.Equal(frame, want) {
+		t.Errorf("got % x, want % x", frame, want)
+	}
+}
+
+func TestWebsocketCommandsRequireAdmin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	review := ts.submit(t, "Ann", "Fine")
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	command := []byte(fmt.Sprintf(`{"action": "approve", "id": %d}`, review.ID))
+
+	if reply := ts.runWebsocketCommand(r, command, false); reply.Type != "error" {
+		t.Errorf("from a reader: got %+v, want an error", reply)
+	}
+	if got := ts.storedReview(t, review.ID).Status; got != statusPending {
+		t.Fatalf("got status %q, want %q", got, statusPending)
+	}
+	if reply := ts.runWebsocketCommand(r, command, true); reply.Type != "review" || reply.Review.Status != statusApproved {
+		t.Errorf("from an admin: got %+v, want the approved review", reply)
+	}
+
+	// Nobody may moderate without credentials configured, whatever the
+	// connection was told
+	ts = newTestServer(t, map[string]string{"API_KEY": ""})
+	review = ts.submit(t, "Ann", "Fine")
+	command = []byte(fmt.Sprintf(`{"action": "approve", "id": %d}`, review.ID))
+	if reply := ts.runWebsocketCommand(r, command, true); reply.Type != "error" || ts.storedReview(t, review.ID).Status != statusPending {
+		t.Errorf("no credentials configured: got %+v, want an error", reply)
+	}
+}